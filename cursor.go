@@ -0,0 +1,178 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+// A Cursor is a position in a SortedMap that can be moved one item at a
+// time in either direction and paused at will. Create one with
+// [SortedMap.Cursor]. For example:
+//
+//	for cursor := tree.Cursor(); cursor.Next(); {
+//		fmt.Println(cursor.Key(), cursor.Value())
+//	}
+//
+// A cursor holds the path from the tree’s root to its current item, so
+// stepping with Next or Prev is amortized O(1) without needing parent
+// pointers in the tree’s nodes.
+//
+// Inserting a new key into or deleting a key from the tree invalidates
+// any of its cursors, although replacing an existing key’s value does
+// not, unless the tree shares its nodes with a version made by
+// [SortedMap.WithInsert] or [SortedMap.WithDelete]: then the tree’s
+// first change of any kind copies its nodes, invalidating its cursors.
+// An invalidated cursor may be repositioned using Seek. To delete items
+// while iterating, use the cursor’s own Delete method.
+type Cursor[K Ordered, V any] struct {
+	tree *SortedMap[K, V]
+	path []*node[K, V] // from the root to the current item
+}
+
+// Cursor returns a new unpositioned cursor for the tree: its first Next
+// moves it to the tree’s smallest key, or its first Prev to the largest.
+func (me *SortedMap[K, V]) Cursor() *Cursor[K, V] {
+	return &Cursor[K, V]{tree: me}
+}
+
+// Valid returns true if the cursor is positioned at an item.
+func (me *Cursor[K, V]) Valid() bool { return len(me.path) > 0 }
+
+// Key returns the current item’s key, or K’s zero value if the cursor
+// isn’t positioned at an item.
+func (me *Cursor[K, V]) Key() K {
+	if len(me.path) == 0 {
+		var zero K
+		return zero
+	}
+	return me.path[len(me.path)-1].key
+}
+
+// Value returns the current item’s value, or V’s zero value if the cursor
+// isn’t positioned at an item.
+func (me *Cursor[K, V]) Value() V {
+	if len(me.path) == 0 {
+		var zero V
+		return zero
+	}
+	return me.path[len(me.path)-1].value
+}
+
+// Next moves the cursor to the item with the next larger key and returns
+// true; or, if there isn’t one, leaves the cursor unpositioned and
+// returns false. If the cursor is unpositioned, Next moves to the item
+// with the smallest key.
+// See also [Prev]
+func (me *Cursor[K, V]) Next() bool {
+	if len(me.path) == 0 {
+		me.pushLeft(me.tree.root)
+		return len(me.path) > 0
+	}
+	if current := me.path[len(me.path)-1]; current.right != nil {
+		me.pushLeft(current.right)
+		return true
+	}
+	for { // climb until we arrive from a left child
+		child := me.pop()
+		if len(me.path) == 0 {
+			return false
+		}
+		if me.path[len(me.path)-1].left == child {
+			return true
+		}
+	}
+}
+
+// Prev moves the cursor to the item with the next smaller key and
+// returns true; or, if there isn’t one, leaves the cursor unpositioned
+// and returns false. If the cursor is unpositioned, Prev moves to the
+// item with the largest key.
+// See also [Next]
+func (me *Cursor[K, V]) Prev() bool {
+	if len(me.path) == 0 {
+		me.pushRight(me.tree.root)
+		return len(me.path) > 0
+	}
+	if current := me.path[len(me.path)-1]; current.left != nil {
+		me.pushRight(current.left)
+		return true
+	}
+	for { // climb until we arrive from a right child
+		child := me.pop()
+		if len(me.path) == 0 {
+			return false
+		}
+		if me.path[len(me.path)-1].right == child {
+			return true
+		}
+	}
+}
+
+// Seek moves the cursor to the item with the first key that is >= key
+// and returns true; or, if there isn’t one, leaves the cursor
+// unpositioned and returns false. This is O(log n).
+func (me *Cursor[K, V]) Seek(key K) bool {
+	me.path = me.path[:0]
+	size := 0 // length of the path to the best candidate so far
+	for root := me.tree.root; root != nil; {
+		me.path = append(me.path, root)
+		if key < root.key {
+			size = len(me.path)
+			root = root.left
+		} else if key > root.key {
+			root = root.right
+		} else {
+			size = len(me.path)
+			break
+		}
+	}
+	me.path = me.path[:size]
+	return size > 0
+}
+
+// Delete deletes the current item from the tree and moves the cursor to
+// the item with the next larger key and returns true; or, if there isn’t
+// one (or if the cursor isn’t positioned at an item), leaves the cursor
+// unpositioned and returns false. This makes it safe to scan and delete
+// selectively in a single pass, e.g.,
+//
+//	cursor := tree.Cursor()
+//	for ok := cursor.Next(); ok; {
+//		if expired(cursor.Value()) {
+//			ok = cursor.Delete()
+//		} else {
+//			ok = cursor.Next()
+//		}
+//	}
+//
+// Since deleting changes the tree’s structure, the cursor finds the
+// successor’s key before deleting and then re-seeks it, so each Delete
+// costs O(log n) for the deletion plus O(log n) for the re-seek.
+func (me *Cursor[K, V]) Delete() bool {
+	if len(me.path) == 0 {
+		return false
+	}
+	key := me.Key()
+	hasNext := me.Next()
+	next := me.Key()
+	me.tree.Delete(key)
+	if !hasNext {
+		return false
+	}
+	return me.Seek(next)
+}
+
+func (me *Cursor[K, V]) pushLeft(root *node[K, V]) {
+	for ; root != nil; root = root.left {
+		me.path = append(me.path, root)
+	}
+}
+
+func (me *Cursor[K, V]) pushRight(root *node[K, V]) {
+	for ; root != nil; root = root.right {
+		me.path = append(me.path, root)
+	}
+}
+
+func (me *Cursor[K, V]) pop() *node[K, V] {
+	root := me.path[len(me.path)-1]
+	me.path = me.path[:len(me.path)-1]
+	return root
+}
@@ -0,0 +1,125 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	var tree SortedMap[int, int]
+	cursor := tree.Cursor()
+	if cursor.Next() || cursor.Prev() || cursor.Valid() {
+		t.Error("expected empty tree's cursor to be unpositioned")
+	}
+	if cursor.Key() != 0 || cursor.Value() != 0 {
+		t.Error("expected zero values")
+	}
+	for i := range 100 {
+		tree.Insert(i*2, i)
+	}
+	var keys []int
+	for cursor := tree.Cursor(); cursor.Next(); {
+		if cursor.Value()*2 != cursor.Key() {
+			t.Errorf("expected %d; got %d", cursor.Key()/2,
+				cursor.Value())
+		}
+		keys = append(keys, cursor.Key())
+	}
+	if !slices.Equal(keys, slices.Collect(tree.Keys())) {
+		t.Errorf("expected all keys in order; got %v", keys)
+	}
+	keys = keys[:0]
+	for cursor := tree.Cursor(); cursor.Prev(); {
+		keys = append(keys, cursor.Key())
+	}
+	expected := slices.Collect(tree.Keys())
+	slices.Reverse(expected)
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected all keys in reverse order; got %v", keys)
+	}
+	cursor = tree.Cursor()
+	for range 10 {
+		cursor.Next()
+	}
+	cursor.Prev()
+	cursor.Prev()
+	cursor.Next()
+	if cursor.Key() != 16 {
+		t.Errorf("expected 16; got %d", cursor.Key())
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i*2, i)
+	}
+	cursor := tree.Cursor()
+	for _, datum := range []struct{ key, expected int }{
+		{-5, 0}, {0, 0}, {1, 2}, {99, 100}, {100, 100}, {197, 198},
+	} {
+		if !cursor.Seek(datum.key) || cursor.Key() != datum.expected {
+			t.Errorf("expected Seek(%d) to reach %d; got %d", datum.key,
+				datum.expected, cursor.Key())
+		}
+	}
+	cursor.Seek(99)
+	cursor.Next()
+	if cursor.Key() != 102 {
+		t.Errorf("expected 102; got %d", cursor.Key())
+	}
+	cursor.Prev()
+	cursor.Prev()
+	if cursor.Key() != 98 {
+		t.Errorf("expected 98; got %d", cursor.Key())
+	}
+	if cursor.Seek(199) || cursor.Valid() {
+		t.Error("expected Seek past the end to leave cursor unpositioned")
+	}
+	if !cursor.Next() || cursor.Key() != 0 {
+		t.Errorf("expected 0; got %d", cursor.Key())
+	}
+	cursor.Seek(198)
+	if cursor.Next() {
+		t.Error("expected Next past the end to return false")
+	}
+}
+
+func TestCursorDelete(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i%3)
+	}
+	cursor := tree.Cursor()
+	if cursor.Delete() {
+		t.Error("expected unpositioned Delete to return false")
+	}
+	for ok := cursor.Next(); ok; {
+		if cursor.Value() == 0 {
+			ok = cursor.Delete()
+		} else {
+			ok = cursor.Next()
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if tree.Len() != 66 {
+		t.Errorf("expected 66; got %d", tree.Len())
+	}
+	if tree.Any(func(_, value int) bool { return value == 0 }) {
+		t.Error("expected all zero values to be deleted")
+	}
+	cursor.Seek(97)
+	if !cursor.Delete() || cursor.Key() != 98 {
+		t.Errorf("expected 97 deleted and cursor at 98; got %d",
+			cursor.Key())
+	}
+	if cursor.Delete() || cursor.Valid() {
+		t.Error("expected deleting the last item to unposition the cursor")
+	}
+	if tree.Contains(97) || tree.Contains(98) || tree.Len() != 64 {
+		t.Error("expected 97 and 98 to be deleted")
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MarshalText implements [encoding.TextMarshaler]. It renders the tree as
+// one key=value line per item in key order, e.g.,
+//
+//	apple=3
+//	banana=12
+//
+// Keys and values may be strings, integers, floats, or bools, or of any
+// type that implements encoding.TextMarshaler. In the rendered keys and
+// values, each backslash is written as \\, each newline as \n, each
+// carriage return as \r, and each = as \=, so every item occupies exactly
+// one line and is split at its first unescaped =.
+// See also [UnmarshalText]
+func (me *SortedMap[K, V]) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	for key, value := range me.All() {
+		keyText, err := formatText(key)
+		if err != nil {
+			return nil, err
+		}
+		valueText, err := formatText(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(escapeText(keyText))
+		buf.WriteByte('=')
+		buf.WriteString(escapeText(valueText))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. It parses text in
+// the format produced by [MarshalText] (ignoring blank lines) and inserts
+// each item into the tree, replacing the values of any keys already
+// present. If any line can’t be parsed, or has a NaN key (see
+// [ErrNaNKey]), an error is returned and the tree is left unchanged.
+func (me *SortedMap[K, V]) UnmarshalText(text []byte) error {
+	var items []Item[K, V]
+	for i, line := range strings.Split(string(text), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		keyText, valueText, err := splitText(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		var item Item[K, V]
+		if err = parseKey(keyText, &item.Key); err != nil {
+			return fmt.Errorf("line %d: invalid key: %w", i+1, err)
+		}
+		if err = parseText(valueText, &item.Value); err != nil {
+			return fmt.Errorf("line %d: invalid value: %w", i+1, err)
+		}
+		items = append(items, item)
+	}
+	for _, item := range items {
+		me.Insert(item.Key, item.Value)
+	}
+	return nil
+}
+
+// EncodeJSON writes the tree to w as a JSON array of [key, value] pairs
+// in key order, e.g.,
+//
+//	[["apple",3],["banana",12]]
+//
+// Each key and value is encoded with [json.Marshal], and the output is
+// streamed through a small buffer rather than built up in memory, so even
+// huge trees can be written using little extra memory.
+// See also [DecodeJSON]
+func (me *SortedMap[K, V]) EncodeJSON(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	out.WriteByte('[')
+	sep := ""
+	for key, value := range me.All() {
+		keyJSON, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		out.WriteString(sep)
+		out.WriteByte('[')
+		out.Write(keyJSON)
+		out.WriteByte(',')
+		out.Write(valueJSON)
+		// A bufio.Writer’s errors are sticky, so this reports any failed
+		// write of this item (or earlier) and stops at once if w is broken
+		if err = out.WriteByte(']'); err != nil {
+			return err
+		}
+		sep = ","
+	}
+	out.WriteByte(']')
+	return out.Flush()
+}
+
+// DecodeJSON reads a JSON array of [key, value] pairs in the format
+// written by [EncodeJSON] from r, inserting each item into the tree as it
+// is parsed (and replacing the values of any keys already present). The
+// pairs need not be in key order. Since items are inserted as they are
+// read, if an error occurs (including reading a NaN key; see
+// [ErrNaNKey]) the tree keeps those items inserted before it.
+func (me *SortedMap[K, V]) DecodeJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	if err := expectJSONDelim(decoder, '['); err != nil {
+		return err
+	}
+	for decoder.More() {
+		if err := expectJSONDelim(decoder, '['); err != nil {
+			return err
+		}
+		var item Item[K, V]
+		if err := decoder.Decode(&item.Key); err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+		if err := checkKey(item.Key); err != nil {
+			return fmt.Errorf("invalid key: %w", err)
+		}
+		if err := decoder.Decode(&item.Value); err != nil {
+			return fmt.Errorf("invalid value: %w", err)
+		}
+		if err := expectJSONDelim(decoder, ']'); err != nil {
+			return err
+		}
+		me.Insert(item.Key, item.Value)
+	}
+	return expectJSONDelim(decoder, ']')
+}
+
+func expectJSONDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %q at offset %d; got %v", delim,
+			decoder.InputOffset(), token)
+	}
+	return nil
+}
+
+// WriteCSV writes the tree to w as CSV using [csv.Writer], one key,value
+// record per item in key order. Keys and values are converted to text
+// the same way as for [MarshalText] (but without its escaping). Any key
+// or value containing a comma, double quote, or newline is enclosed in
+// double quotes with each double quote doubled, e.g., the key
+// Smith, "Jo" is written as "Smith, ""Jo""".
+// See also [ReadCSV]
+func (me *SortedMap[K, V]) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	for key, value := range me.All() {
+		keyText, err := formatText(key)
+		if err != nil {
+			return err
+		}
+		valueText, err := formatText(value)
+		if err != nil {
+			return err
+		}
+		if err = writer.Write([]string{keyText, valueText}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ReadCSV reads CSV in the format written by [WriteCSV] from r and inserts
+// each item into the tree, replacing the values of any keys already
+// present. Every record must have exactly two fields, and quoted fields
+// may contain commas, doubled double quotes, and newlines. If any record
+// can’t be parsed, or has a NaN key (see [ErrNaNKey]), an error is
+// returned and the tree is left unchanged.
+func (me *SortedMap[K, V]) ReadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	items := make([]Item[K, V], len(records))
+	for i, record := range records {
+		if err = parseKey(record[0], &items[i].Key); err != nil {
+			return fmt.Errorf("record %d: invalid key: %w", i+1, err)
+		}
+		if err = parseText(record[1], &items[i].Value); err != nil {
+			return fmt.Errorf("record %d: invalid value: %w", i+1, err)
+		}
+	}
+	for _, item := range items {
+		me.Insert(item.Key, item.Value)
+	}
+	return nil
+}
+
+// Checksum returns a 64-bit FNV-1a hash of the tree’s contents. Each key
+// and value, in key order, is converted to text as for [MarshalText] and
+// hashed as its length (as a uvarint) followed by its bytes, so trees
+// with the same items always have the same checksum, however they were
+// built. Values of other types (e.g., structs or slices) are converted
+// using fmt’s %v format, which is only stable across processes if their
+// contents are (e.g., it is not for pointers). For example:
+//
+//	if tree.Checksum() != cachedChecksum { … }
+//
+// A checksum can only show that trees differ: different trees may
+// (rarely) have the same checksum.
+func (me *SortedMap[K, V]) Checksum() uint64 {
+	hash := fnv.New64a()
+	var buf []byte
+	write := func(x any) {
+		text, err := formatText(x)
+		if err != nil {
+			text = fmt.Sprintf("%v", x)
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(text)))
+		buf = append(buf, text...)
+		hash.Write(buf)
+	}
+	for key, value := range me.All() {
+		write(key)
+		write(value)
+	}
+	return hash.Sum64()
+}
+
+func escapeText(text string) string {
+	if !strings.ContainsAny(text, "\\\n\r=") {
+		return text
+	}
+	var out strings.Builder
+	for i := range len(text) {
+		switch c := text[i]; c {
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '=':
+			out.WriteString(`\=`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// splitText splits an escaped key=value line at its first unescaped =
+// and returns the unescaped key and value.
+func splitText(line string) (string, string, error) {
+	var key, value strings.Builder
+	out := &key
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '=' && out == &key {
+			out = &value
+			continue
+		}
+		if c != '\\' {
+			out.WriteByte(c)
+			continue
+		}
+		if i++; i == len(line) {
+			return "", "", fmt.Errorf("incomplete escape at end of %q",
+				line)
+		}
+		switch line[i] {
+		case '\\':
+			out.WriteByte('\\')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case '=':
+			out.WriteByte('=')
+		default:
+			return "", "", fmt.Errorf("invalid escape \\%c in %q", line[i],
+				line)
+		}
+	}
+	if out == &key {
+		return "", "", fmt.Errorf("missing = in %q", line)
+	}
+	return key.String(), value.String(), nil
+}
+
+// formatText returns the textual form of x which must be a string,
+// integer, float, or bool, or implement encoding.TextMarshaler.
+// See also [parseText]
+func formatText(x any) (string, error) {
+	if marshaler, ok := x.(encoding.TextMarshaler); ok {
+		text, err := marshaler.MarshalText()
+		return string(text), err
+	}
+	value := reflect.ValueOf(x)
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(value.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(value.Float(), 'g', -1,
+			value.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", x)
+}
+
+// parseKey is like parseText but for keys, so it rejects NaN.
+func parseKey[K Ordered](text string, key *K) error {
+	if err := parseText(text, key); err != nil {
+		return err
+	}
+	return checkKey(*key)
+}
+
+// checkKey returns [ErrNaNKey] if the key is NaN, since
+// a NaN key compares equal to every key in insert and so would silently
+// overwrite some other key’s value.
+func checkKey[K Ordered](key K) error {
+	if key != key { // only true for NaN
+		return ErrNaNKey
+	}
+	return nil
+}
+
+// parseText parses the text into the value ptr points to (which must be
+// a string, integer, float, or bool, or implement
+// encoding.TextUnmarshaler).
+// See also [formatText]
+func parseText(text string, ptr any) error {
+	if unmarshaler, ok := ptr.(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+	value := reflect.ValueOf(ptr).Elem()
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+var (
+	_ encoding.TextMarshaler   = (*SortedMap[string, int])(nil)
+	_ encoding.TextUnmarshaler = (*SortedMap[string, int])(nil)
+)
+
+// failingWriter fails every write, e.g., like a broken pipe.
+type failingWriter struct{ writes int }
+
+var errFailingWriter = errors.New("write failed")
+
+func (me *failingWriter) Write([]byte) (int, error) {
+	me.writes++
+	return 0, errFailingWriter
+}
+
+// countedJSON counts how many times it is marshaled.
+type countedJSON struct{ count *int }
+
+func (me countedJSON) MarshalJSON() ([]byte, error) {
+	*me.count++
+	return []byte("0"), nil
+}
+
+func TestMarshalText(t *testing.T) {
+	var tree SortedMap[string, string]
+	tree.Insert("plain", "value")
+	tree.Insert("a=b", "c=d")
+	tree.Insert("multi\nline", "back\\slash\r\n")
+	tree.Insert("", "")
+	text, err := tree.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "=\n" + `a\=b=c\=d` + "\n" + `multi\nline=back\\slash\r\n` +
+		"\nplain=value\n"
+	if string(text) != expected {
+		t.Errorf("expected %q; got %q", expected, string(text))
+	}
+	var other SortedMap[string, string]
+	if err := other.UnmarshalText(text); err != nil {
+		t.Fatal(err)
+	}
+	if other.Len() != tree.Len() {
+		t.Errorf("expected %d; got %d", tree.Len(), other.Len())
+	}
+	for key, value := range tree.All() {
+		if v, ok := other.Find(key); !ok || v != value {
+			t.Errorf("expected %q=%q; got %q", key, value, v)
+		}
+	}
+}
+
+func TestMarshalTextNumbers(t *testing.T) {
+	var tree SortedMap[int, float64]
+	for i := range 5 {
+		tree.Insert(i-2, float64(i)/4)
+	}
+	text, err := tree.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "-2=0\n-1=0.25\n0=0.5\n1=0.75\n2=1\n"
+	if string(text) != expected {
+		t.Errorf("expected %q; got %q", expected, string(text))
+	}
+	var other SortedMap[int, float64]
+	if err := other.UnmarshalText([]byte("\n" + string(text) +
+		"\n")); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range tree.All() {
+		if v, _ := other.Find(key); v != value {
+			t.Errorf("expected %d=%g; got %g", key, value, v)
+		}
+	}
+	for _, bad := range []string{"1", "x=1", "1=y", "1=2\\", "1=\\t"} {
+		if err := other.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+	if other.Len() != 5 {
+		t.Errorf("expected failed parses to change nothing; got %d",
+			other.Len())
+	}
+	var unsupported SortedMap[int, []int]
+	unsupported.Insert(1, []int{1})
+	if _, err := unsupported.MarshalText(); err == nil {
+		t.Error("expected error for unsupported value type")
+	}
+}
+
+func TestEncodeJSONWriteError(t *testing.T) {
+	var tree SortedMap[int, countedJSON]
+	count := 0
+	for i := range 100_000 {
+		tree.Insert(i, countedJSON{&count})
+	}
+	var w failingWriter
+	if err := tree.EncodeJSON(&w); !errors.Is(err, errFailingWriter) {
+		t.Errorf("expected %v; got %v", errFailingWriter, err)
+	}
+	if w.writes != 1 || count > 1000 {
+		t.Errorf("expected to stop at the first failed write; got %d "+
+			"writes and %d items encoded", w.writes, count)
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	var tree SortedMap[string, []int]
+	tree.Insert("b", []int{2, 3})
+	tree.Insert("a \"quoted\"", []int{1})
+	tree.Insert("c", nil)
+	var buf bytes.Buffer
+	if err := tree.EncodeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[["a \"quoted\"",[1]],["b",[2,3]],["c",null]]`
+	if buf.String() != expected {
+		t.Errorf("expected %s; got %s", expected, buf.String())
+	}
+	var other SortedMap[string, []int]
+	other.Insert("b", []int{99})
+	if err := other.DecodeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if other.Len() != 3 {
+		t.Errorf("expected 3; got %d", other.Len())
+	}
+	for key, value := range tree.All() {
+		if v, ok := other.Find(key); !ok || len(v) != len(value) {
+			t.Errorf("expected %q=%v; got %v", key, value, v)
+		}
+	}
+	var empty SortedMap[int, int]
+	buf.Reset()
+	if err := empty.EncodeJSON(&buf); err != nil || buf.String() != "[]" {
+		t.Errorf("expected []; got %s %v", buf.String(), err)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var tree SortedMap[int, string]
+	err := tree.DecodeJSON(strings.NewReader(
+		` [ [3, "three"], [1, "one"],
+		[2, "two"] ] `))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := tree.KeysSlice(); len(keys) != 3 || keys[0] != 1 ||
+		keys[2] != 3 {
+		t.Errorf("expected [1 2 3]; got %v", keys)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	for _, text := range []string{`{}`, `[[1]]`, `[["x", "y"]]`,
+		`[[4, "four", 5]]`, `[[4, "four"]`, `[4]`} {
+		var other SortedMap[int, string]
+		if err := other.DecodeJSON(strings.NewReader(text)); err == nil {
+			t.Errorf("expected error for %s", text)
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var tree SortedMap[string, float64]
+	tree.Insert("plain", 1.5)
+	tree.Insert(`Smith, "Jo"`, -2)
+	tree.Insert("two\nlines", 0)
+	var buf bytes.Buffer
+	if err := tree.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `"Smith, ""Jo""",-2` + "\nplain,1.5\n\"two\nlines\",0\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q; got %q", expected, buf.String())
+	}
+	var other SortedMap[string, float64]
+	if err := other.ReadCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if other.Len() != tree.Len() {
+		t.Errorf("expected %d; got %d", tree.Len(), other.Len())
+	}
+	for key, value := range tree.All() {
+		if v, ok := other.Find(key); !ok || v != value {
+			t.Errorf("expected %q=%g; got %g", key, value, v)
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	var tree SortedMap[int, bool]
+	tree.Insert(1, false)
+	for _, text := range []string{"2,true\n3\n", "2,true,x\n", "x,true\n",
+		"2,maybe\n"} {
+		if err := tree.ReadCSV(strings.NewReader(text)); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+	if tree.Len() != 1 {
+		t.Errorf("expected the tree to be unchanged; got %d", tree.Len())
+	}
+	if err := tree.ReadCSV(strings.NewReader("3,true\n1,true\n")); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 2 || !tree.GetOr(1, false) || !tree.GetOr(3, false) {
+		t.Errorf("expected 1 and 3 to be true; got %v", tree.ValuesSlice())
+	}
+}
+
+// nanFloat is a float64 that unmarshals the JSON string "NaN" to NaN.
+type nanFloat float64
+
+func (me *nanFloat) UnmarshalJSON(data []byte) error {
+	if string(data) == `"NaN"` {
+		*me = nanFloat(math.NaN())
+		return nil
+	}
+	var x float64
+	err := json.Unmarshal(data, &x)
+	*me = nanFloat(x)
+	return err
+}
+
+func TestDecodeNaNKey(t *testing.T) {
+	var tree SortedMap[float64, int]
+	tree.Insert(5, 5)
+	if err := tree.ReadCSV(strings.NewReader("1,1\nNaN,1\n")); !errors.Is(
+		err, ErrNaNKey) {
+		t.Errorf("expected %v; got %v", ErrNaNKey, err)
+	}
+	if err := tree.UnmarshalText([]byte("2=2\nNaN=1\n")); !errors.Is(err,
+		ErrNaNKey) {
+		t.Errorf("expected %v; got %v", ErrNaNKey, err)
+	}
+	if value, _ := tree.Find(5); tree.Len() != 1 || value != 5 {
+		t.Errorf("expected only 5=5; got %v", tree.KeysSlice())
+	}
+	var other SortedMap[nanFloat, int]
+	other.Insert(5, 5)
+	err := other.DecodeJSON(strings.NewReader(`[[1,1],["NaN",2]]`))
+	if !errors.Is(err, ErrNaNKey) {
+		t.Errorf("expected %v; got %v", ErrNaNKey, err)
+	}
+	if value, _ := other.Find(5); other.Len() != 2 || value != 5 {
+		t.Errorf("expected 1=1 and 5=5; got %v", other.KeysSlice())
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var a, b SortedMap[string, string]
+	var empty SortedMap[string, string]
+	words := strings.Fields("m c x a q z b")
+	for _, word := range words {
+		a.Insert(word, strings.ToUpper(word))
+	}
+	for i := len(words) - 1; i >= 0; i-- {
+		b.Insert(words[i], strings.ToUpper(words[i]))
+	}
+	if a.Checksum() != b.Checksum() {
+		t.Error("expected equal checksums for equal trees")
+	}
+	if a.Checksum() == empty.Checksum() {
+		t.Error("expected different checksums")
+	}
+	b.Insert("q", "Q!")
+	if a.Checksum() == b.Checksum() {
+		t.Error("expected different checksums for different values")
+	}
+	var c, d SortedMap[string, string]
+	c.Insert("ab", "c")
+	d.Insert("a", "bc")
+	if c.Checksum() == d.Checksum() {
+		t.Error("expected different checksums for differently split text")
+	}
+	var e, f SortedMap[int, []int]
+	e.Insert(1, []int{2, 3})
+	f.Insert(1, []int{2, 3})
+	if e.Checksum() != f.Checksum() {
+		t.Error("expected equal checksums for equal slice values")
+	}
+	f.Insert(1, []int{2})
+	if e.Checksum() == f.Checksum() {
+		t.Error("expected different checksums for different slice values")
+	}
+}
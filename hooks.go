@@ -0,0 +1,70 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+// Hooks holds optional callbacks that a SortedMap calls whenever its
+// contents change; set them with [SortedMap.SetHooks]. Any callback may
+// be nil, in which case it is skipped. For example, to keep a secondary
+// index up to date:
+//
+//	tree.SetHooks(sortedmap.Hooks[string, User]{
+//		OnInsert: func(id string, user User) { byEmail[user.Email] = id },
+//		OnDelete: func(_ string, user User) { delete(byEmail, user.Email) },
+//	})
+//
+// Each callback is called after the change it reports has been made,
+// and only if something actually changed (e.g., deleting a key that
+// isn’t in the tree calls nothing). OnInsert is called when a new key is
+// added, OnReplace when an existing key’s value is replaced (e.g., by
+// [SortedMap.Insert], [SortedMap.InsertWith], or
+// [SortedMap.ReplaceValue]), and OnDelete when a key is deleted. Methods
+// that delete every item ([SortedMap.Clear], [SortedMap.Drain], and
+// [SortedMap.Release]) call OnDelete for each item in key order, so they
+// become O(n). Rebuilding methods such as [SortedMap.Rebuild] don’t
+// change the contents and so call nothing. Changes made through
+// pointers returned by [SortedMap.FindRef], and the key shifts made by
+// [DeleteAndShift] (although not its deletion), aren’t reported.
+//
+// A callback must not change the tree.
+type Hooks[K Ordered, V any] struct {
+	OnInsert  func(key K, value V)
+	OnReplace func(key K, oldValue, newValue V)
+	OnDelete  func(key K, value V)
+}
+
+// SetHooks sets the tree’s hooks, replacing any previously set. To
+// remove them all, pass a zero Hooks value.
+func (me *SortedMap[K, V]) SetHooks(hooks Hooks[K, V]) {
+	if hooks.OnInsert == nil && hooks.OnReplace == nil &&
+		hooks.OnDelete == nil {
+		me.hooks = nil
+	} else {
+		me.hooks = &hooks
+	}
+}
+
+// The methods below are all safe to call on a nil *Hooks.
+
+func (me *Hooks[K, V]) inserted(key K, value V) {
+	if me != nil && me.OnInsert != nil {
+		me.OnInsert(key, value)
+	}
+}
+
+func (me *Hooks[K, V]) replaced(key K, oldValue, newValue V) {
+	if me != nil && me.OnReplace != nil {
+		me.OnReplace(key, oldValue, newValue)
+	}
+}
+
+func (me *Hooks[K, V]) deleted(key K, value V) {
+	if me != nil && me.OnDelete != nil {
+		me.OnDelete(key, value)
+	}
+}
+
+// watchesDeletes returns true if there is an OnDelete hook, in which case
+// deleted values must be captured before they are deleted.
+func (me *Hooks[K, V]) watchesDeletes() bool {
+	return me != nil && me.OnDelete != nil
+}
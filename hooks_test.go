@@ -0,0 +1,126 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func hookedTree() (*SortedMap[string, int], *[]string) {
+	var events []string
+	var tree SortedMap[string, int]
+	tree.SetHooks(Hooks[string, int]{
+		OnInsert: func(key string, value int) {
+			if !tree.Contains(key) {
+				events = append(events, "called before insert")
+			}
+			events = append(events, fmt.Sprintf("+%s=%d", key, value))
+		},
+		OnReplace: func(key string, oldValue, newValue int) {
+			events = append(events, fmt.Sprintf("~%s=%d>%d", key,
+				oldValue, newValue))
+		},
+		OnDelete: func(key string, value int) {
+			if tree.Contains(key) {
+				events = append(events, "called before delete")
+			}
+			events = append(events, fmt.Sprintf("-%s=%d", key, value))
+		},
+	})
+	return &tree, &events
+}
+
+func checkEvents(t *testing.T, events *[]string, expected string) {
+	t.Helper()
+	if got := strings.Join(*events, " "); got != expected {
+		t.Errorf("expected %q; got %q", expected, got)
+	}
+	*events = (*events)[:0]
+}
+
+func TestHooksInsert(t *testing.T) {
+	tree, events := hookedTree()
+	tree.Insert("a", 1)
+	tree.Insert("a", 2)
+	tree.InsertIfAbsent("a", 3)
+	tree.InsertIfAbsent("b", 4)
+	checkEvents(t, events, "+a=1 ~a=1>2 +b=4")
+	sum := func(old, incoming int) int { return old + incoming }
+	tree.InsertWith("b", 10, sum)
+	tree.InsertWith("c", 10, sum)
+	tree.MergeSeq(func(yield func(string, int) bool) {
+		_ = yield("c", 1) && yield("d", 1)
+	}, sum)
+	checkEvents(t, events, "~b=4>14 +c=10 ~c=10>11 +d=1")
+	tree.ReplaceValue("a", 5)
+	tree.ReplaceValue("z", 5)
+	tree.Swap("a", "b")
+	tree.Swap("a", "a")
+	eq := func(a, b int) bool { return a == b }
+	tree.CompareAndSwapValue("c", 11, 0, eq)
+	tree.CompareAndSwapValue("c", 11, 1, eq)
+	checkEvents(t, events, "~a=2>5 ~a=5>14 ~b=14>5 ~c=11>0")
+	strict := NewWithPolicy[string, int](DuplicateError)
+	replaced := 0
+	strict.SetHooks(Hooks[string, int]{OnReplace: func(string, int, int) {
+		replaced++
+	}})
+	_ = strict.InsertChecked("x", 1)
+	if err := strict.InsertChecked("x", 2); err == nil || replaced != 0 {
+		t.Errorf("expected an error and no replacement; got %d", replaced)
+	}
+}
+
+func TestHooksDelete(t *testing.T) {
+	tree, events := hookedTree()
+	for i, word := range strings.Fields("a b c d e f g") {
+		tree.Insert(word, i)
+	}
+	checkEvents(t, events, "+a=0 +b=1 +c=2 +d=3 +e=4 +f=5 +g=6")
+	tree.Delete("c")
+	tree.Delete("c")
+	tree.DeleteMin()
+	tree.DeleteMax()
+	tree.Rename("b", "z")
+	checkEvents(t, events, "-c=2 -a=0 -g=6 -b=1 +z=1")
+	tree.Rebuild()
+	tree.Shrink()
+	checkEvents(t, events, "")
+	tree.Clear()
+	checkEvents(t, events, "-d=3 -e=4 -f=5 -z=1")
+	tree.Insert("q", 1)
+	tree.Drain()
+	tree.Insert("r", 2)
+	tree.Release()
+	checkEvents(t, events, "+q=1 -q=1 +r=2 -r=2")
+	tree.SetHooks(Hooks[string, int]{})
+	tree.Insert("s", 3)
+	tree.Delete("s")
+	checkEvents(t, events, "")
+}
+
+func TestHooksPartial(t *testing.T) {
+	var tree SortedMap[int, int]
+	count := 0
+	tree.SetHooks(Hooks[int, int]{OnDelete: func(int, int) { count++ }})
+	for i := range 10 {
+		tree.Insert(i, i)
+		tree.Insert(i, -i)
+	}
+	tree.BulkLoadSorted(nil)
+	tree.DeleteFunc(func(key, _ int) bool { return key%2 == 0 })
+	if count != 5 || tree.Len() != 5 {
+		t.Errorf("expected 5 deletions; got %d", count)
+	}
+	var loaded SortedMap[int, int]
+	inserted := 0
+	loaded.SetHooks(Hooks[int, int]{OnInsert: func(int, int) {
+		inserted++
+	}})
+	loaded.BulkLoadSorted([]Item[int, int]{{1, 1}, {2, 2}, {3, 3}})
+	loaded.Clear()
+	if inserted != 3 || loaded.Len() != 0 {
+		t.Errorf("expected 3 insertions; got %d", inserted)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import "iter"
+
+// Keyed is the constraint for the keys of a [SortedMapKeyed]: a type
+// with a Less method that defines a strict weak ordering (like < does for
+// numbers). Two keys a and b are considered equal if neither a.Less(b)
+// nor b.Less(a).
+type Keyed[K any] interface {
+	Less(other K) bool
+}
+
+// A SortedMapKeyed is a key-value map like [SortedMap] except that its
+// keys are ordered by their Less method rather than by <, so they may be
+// structs or other composite types. For example:
+//
+//	type Name struct{ Last, First string }
+//
+//	func (me Name) Less(other Name) bool {
+//		if me.Last != other.Last {
+//			return me.Last < other.Last
+//		}
+//		return me.First < other.First
+//	}
+//
+//	var people SortedMapKeyed[Name, int]
+//	people.Insert(Name{"Lovelace", "Ada"}, 1815)
+//
+// It provides the core map operations; for anything more, use a
+// SortedMap with a key of a type that supports <.
+//
+// A SortedMapKeyed zero value is usable.
+type SortedMapKeyed[K Keyed[K], V any] struct {
+	root *keyedNode[K, V]
+	size int
+}
+
+type keyedNode[K Keyed[K], V any] struct {
+	key         K
+	value       V
+	red         bool
+	left, right *keyedNode[K, V]
+}
+
+// Insert inserts a new key-value item into the tree and returns false; or
+// replaces the value of the existing item with an equal key and returns
+// true. This is the same as [SortedMap.Insert]. For example:
+//
+//	replaced := people.Insert(name, born)
+func (me *SortedMapKeyed[K, V]) Insert(key K, value V) bool {
+	size := me.size
+	me.root = me.insert(me.root, key, value)
+	me.root.red = false
+	return size == me.size
+}
+
+func (me *SortedMapKeyed[K, V]) insert(root *keyedNode[K, V], key K,
+	value V,
+) *keyedNode[K, V] {
+	if root == nil {
+		me.size++
+		return &keyedNode[K, V]{key: key, value: value, red: true}
+	}
+	if key.Less(root.key) {
+		root.left = me.insert(root.left, key, value)
+	} else if root.key.Less(key) {
+		root.right = me.insert(root.right, key, value)
+	} else {
+		root.value = value
+	}
+	return keyedFixUp(root)
+}
+
+// Len returns the number of items in the tree.
+func (me *SortedMapKeyed[K, V]) Len() int { return me.size }
+
+// Contains returns true if the key is in the tree and false otherwise.
+func (me *SortedMapKeyed[K, V]) Contains(key K) bool {
+	return me.findNode(key) != nil
+}
+
+// Find returns the value with the given key and true, or V’s zero value
+// and false if the key isn’t in the tree.
+func (me *SortedMapKeyed[K, V]) Find(key K) (V, bool) {
+	if root := me.findNode(key); root != nil {
+		return root.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (me *SortedMapKeyed[K, V]) findNode(key K) *keyedNode[K, V] {
+	root := me.root
+	for root != nil {
+		if key.Less(root.key) {
+			root = root.left
+		} else if root.key.Less(key) {
+			root = root.right
+		} else {
+			return root
+		}
+	}
+	return nil
+}
+
+// Delete deletes the key-value item with the given key from the tree and
+// returns true, or does nothing and returns false if the key isn’t in
+// the tree.
+func (me *SortedMapKeyed[K, V]) Delete(key K) bool {
+	deleted := false
+	if me.root != nil {
+		if !keyedIsRed(me.root.left) && !keyedIsRed(me.root.right) {
+			me.root.red = true
+		}
+		if me.root, deleted = keyedDelete(me.root,
+			key); me.root != nil {
+			me.root.red = false
+		}
+	}
+	if deleted {
+		me.size--
+	}
+	return deleted
+}
+
+// Clear deletes all the tree’s key-value items.
+func (me *SortedMapKeyed[K, V]) Clear() {
+	me.root = nil
+	me.size = 0
+}
+
+// All is a range function for use as an iterable in a for … range loop
+// that returns all of the tree’s keys and values in key order:
+//
+//	for key, value := range tree.All()
+func (me *SortedMapKeyed[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		var stack [maxHeight]*keyedNode[K, V]
+		depth := 0
+		for root := me.root; root != nil || depth > 0; {
+			for ; root != nil; root = root.left {
+				stack[depth] = root
+				depth++
+			}
+			depth--
+			root = stack[depth]
+			if !yield(root.key, root.value) {
+				return
+			}
+			root = root.right
+		}
+	}
+}
+
+// Keys is a range function for use as an iterable in a for … range loop
+// that returns all of the tree’s keys in order.
+func (me *SortedMapKeyed[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for key := range me.All() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// Values is a range function for use as an iterable in a for … range
+// loop that returns all of the tree’s values in key order.
+func (me *SortedMapKeyed[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, value := range me.All() {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// The functions below are the same as their SortedMap counterparts
+// (without the subtree sizes), but for keyedNodes. The two copies of the
+// balancing code must be kept in step: any fix to one (e.g., to insert,
+// delete_, or their rotations) must also be made to the other.
+
+func keyedIsRed[K Keyed[K], V any](root *keyedNode[K, V]) bool {
+	return root != nil && root.red
+}
+
+func keyedColorFlip[K Keyed[K], V any](root *keyedNode[K, V]) {
+	root.red = !root.red
+	if root.left != nil {
+		root.left.red = !root.left.red
+	}
+	if root.right != nil {
+		root.right.red = !root.right.red
+	}
+}
+
+func keyedRotateLeft[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	x := root.right
+	root.right = x.left
+	x.left = root
+	x.red = root.red
+	root.red = true
+	return x
+}
+
+func keyedRotateRight[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	x := root.left
+	root.left = x.right
+	x.right = root
+	x.red = root.red
+	root.red = true
+	return x
+}
+
+// keyedFixUp is used both after insertion (where it is insertRotation)
+// and after deletion (where it is fixUp); without subtree sizes these are
+// the same.
+func keyedFixUp[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	if keyedIsRed(root.right) && !keyedIsRed(root.left) {
+		root = keyedRotateLeft(root)
+	}
+	if keyedIsRed(root.left) && keyedIsRed(root.left.left) {
+		root = keyedRotateRight(root)
+	}
+	if keyedIsRed(root.left) && keyedIsRed(root.right) {
+		keyedColorFlip(root)
+	}
+	return root
+}
+
+func keyedDelete[K Keyed[K], V any](root *keyedNode[K, V], key K) (
+	*keyedNode[K, V], bool,
+) {
+	deleted := false
+	if key.Less(root.key) {
+		if root.left != nil {
+			if !keyedIsRed(root.left) && !keyedIsRed(root.left.left) {
+				root = keyedMoveRedLeft(root)
+			}
+			root.left, deleted = keyedDelete(root.left, key)
+		}
+	} else {
+		if keyedIsRed(root.left) {
+			root = keyedRotateRight(root)
+		}
+		if root.right == nil && !root.key.Less(key) &&
+			!key.Less(root.key) {
+			return nil, true
+		}
+		if root.right != nil {
+			root, deleted = keyedDeleteRight(root, key)
+		}
+	}
+	return keyedFixUp(root), deleted
+}
+
+func keyedMoveRedLeft[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	keyedColorFlip(root)
+	if root.right != nil && keyedIsRed(root.right.left) {
+		root.right = keyedRotateRight(root.right)
+		root = keyedRotateLeft(root)
+		keyedColorFlip(root)
+	}
+	return root
+}
+
+func keyedDeleteRight[K Keyed[K], V any](root *keyedNode[K, V], key K) (
+	*keyedNode[K, V], bool,
+) {
+	deleted := false
+	if !keyedIsRed(root.right) && !keyedIsRed(root.right.left) {
+		root = keyedMoveRedRight(root)
+	}
+	if !root.key.Less(key) && !key.Less(root.key) {
+		smallest := root.right
+		for smallest.left != nil {
+			smallest = smallest.left
+		}
+		root.key = smallest.key
+		root.value = smallest.value
+		root.right = keyedDeleteMinimum(root.right)
+		deleted = true
+	} else {
+		root.right, deleted = keyedDelete(root.right, key)
+	}
+	return root, deleted
+}
+
+func keyedMoveRedRight[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	keyedColorFlip(root)
+	if root.left != nil && keyedIsRed(root.left.left) {
+		root = keyedRotateRight(root)
+		keyedColorFlip(root)
+	}
+	return root
+}
+
+func keyedDeleteMinimum[K Keyed[K], V any](
+	root *keyedNode[K, V],
+) *keyedNode[K, V] {
+	if root.left == nil {
+		return nil
+	}
+	if !keyedIsRed(root.left) && !keyedIsRed(root.left.left) {
+		root = keyedMoveRedLeft(root)
+	}
+	root.left = keyedDeleteMinimum(root.left)
+	return keyedFixUp(root)
+}
@@ -0,0 +1,143 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type testName struct{ Last, First string }
+
+func (me testName) Less(other testName) bool {
+	if me.Last != other.Last {
+		return me.Last < other.Last
+	}
+	return me.First < other.First
+}
+
+func TestSortedMapKeyed(t *testing.T) {
+	var people SortedMapKeyed[testName, int]
+	if people.Insert(testName{"Lovelace", "Ada"}, 1815) ||
+		people.Insert(testName{"Hopper", "Grace"}, 1906) ||
+		people.Insert(testName{"Lovelace", "Byron"}, 1816) {
+		t.Error("expected new keys to be inserted")
+	}
+	if !people.Insert(testName{"Hopper", "Grace"}, 1907) {
+		t.Error("expected an existing key's value to be replaced")
+	}
+	var years SortedMap[string, int]
+	if years.Insert("Hopper", 1906) || !years.Insert("Hopper", 1907) {
+		t.Error("expected SortedMap.Insert to return the same")
+	}
+	if people.Len() != 3 {
+		t.Errorf("expected 3; got %d", people.Len())
+	}
+	if year, ok := people.Find(testName{"Hopper", "Grace"}); !ok ||
+		year != 1907 {
+		t.Errorf("expected 1907; got %d", year)
+	}
+	if people.Contains(testName{"Hopper", "Ada"}) {
+		t.Error("expected Hopper, Ada to be missing")
+	}
+	var names []string
+	for name := range people.Keys() {
+		names = append(names, name.First)
+	}
+	if got := strings.Join(names, " "); got != "Grace Ada Byron" {
+		t.Errorf("expected Grace Ada Byron; got %q", got)
+	}
+	if years := slices.Collect(people.Values()); !slices.Equal(years,
+		[]int{1907, 1815, 1816}) {
+		t.Errorf("expected [1907 1815 1816]; got %v", years)
+	}
+	if !people.Delete(testName{"Lovelace", "Ada"}) ||
+		people.Delete(testName{"Lovelace", "Ada"}) || people.Len() != 2 {
+		t.Error("expected Lovelace, Ada to be deleted once")
+	}
+	for range people.All() {
+		break
+	}
+	people.Clear()
+	if people.Len() != 0 || people.Contains(testName{"Hopper", "Grace"}) {
+		t.Error("expected an empty tree")
+	}
+}
+
+func TestSortedMapKeyedRandom(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	var tree SortedMapKeyed[testName, int]
+	shadow := map[testName]int{}
+	for i := range 5000 {
+		name := testName{fmt.Sprint(rng.IntN(30)), fmt.Sprint(rng.IntN(30))}
+		if rng.IntN(3) == 0 {
+			_, ok := shadow[name]
+			if tree.Delete(name) != ok {
+				t.Fatalf("%d: Delete(%v) disagrees", i, name)
+			}
+			delete(shadow, name)
+		} else {
+			tree.Insert(name, i)
+			shadow[name] = i
+		}
+		if err := checkKeyed(tree.root, true); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	if tree.Len() != len(shadow) {
+		t.Errorf("expected %d; got %d", len(shadow), tree.Len())
+	}
+	var previous *testName
+	for name, value := range tree.All() {
+		if previous != nil && !previous.Less(name) {
+			t.Fatalf("%v is out of order after %v", name, *previous)
+		}
+		if shadow[name] != value {
+			t.Errorf("expected %d; got %d", shadow[name], value)
+		}
+		previous = &name
+	}
+}
+
+// checkKeyed returns an error if the tree isn't a valid left-leaning
+// red-black tree.
+func checkKeyed(root *keyedNode[testName, int], isRoot bool) error {
+	_, err := keyedBlackHeight(root)
+	if err == nil && isRoot && keyedIsRed(root) {
+		err = fmt.Errorf("red root %v", root.key)
+	}
+	return err
+}
+
+func keyedBlackHeight(root *keyedNode[testName, int]) (int, error) {
+	if root == nil {
+		return 0, nil
+	}
+	if keyedIsRed(root.right) {
+		return 0, fmt.Errorf("red right link at %v", root.key)
+	}
+	if root.red && keyedIsRed(root.left) {
+		return 0, fmt.Errorf("red-red at %v", root.key)
+	}
+	if (root.left != nil && !root.left.key.Less(root.key)) ||
+		(root.right != nil && !root.key.Less(root.right.key)) {
+		return 0, fmt.Errorf("misordered at %v", root.key)
+	}
+	left, err := keyedBlackHeight(root.left)
+	if err != nil {
+		return 0, err
+	}
+	right, err := keyedBlackHeight(root.right)
+	if err != nil {
+		return 0, err
+	}
+	if left != right {
+		return 0, fmt.Errorf("unequal black heights at %v", root.key)
+	}
+	if !root.red {
+		left++
+	}
+	return left, nil
+}
@@ -0,0 +1,138 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import (
+	"iter"
+	"slices"
+)
+
+// A SortedMultiMap is a < ordered key-value map that may hold any number
+// of values per key. It is built on a [SortedMap] whose values are the
+// slices of values for each key, held in insertion order.
+//
+// A SortedMultiMap zero value is usable.
+// Create it with statements like these:
+//
+//	var multimap SortedMultiMap[string, int]
+//	multimap := SortedMultiMap[int, int]{}
+type SortedMultiMap[K Ordered, V any] struct {
+	tree SortedMap[K, []V]
+	size int
+}
+
+// Insert adds the value to those held for the given key; it never
+// replaces existing values. For example:
+//
+//	multimap.Insert(timestamp, record)
+func (me *SortedMultiMap[K, V]) Insert(key K, value V) {
+	if root := me.tree.findNode(key); root != nil {
+		root.value = append(root.value, value)
+	} else {
+		me.tree.Insert(key, []V{value})
+	}
+	me.size++
+}
+
+// Len returns the total number of values in the multimap.
+// See also [KeyCount] and [Count]
+func (me *SortedMultiMap[K, V]) Len() int { return me.size }
+
+// KeyCount returns the number of distinct keys in the multimap.
+// See also [Len]
+func (me *SortedMultiMap[K, V]) KeyCount() int { return me.tree.Len() }
+
+// Count returns the number of values held for the given key (0 if the
+// key isn’t present).
+func (me *SortedMultiMap[K, V]) Count(key K) int {
+	if root := me.tree.findNode(key); root != nil {
+		return len(root.value)
+	}
+	return 0
+}
+
+// Contains returns true if the key is in the multimap and false
+// otherwise.
+func (me *SortedMultiMap[K, V]) Contains(key K) bool {
+	return me.tree.Contains(key)
+}
+
+// FindAll returns a copy of the values held for the given key in
+// insertion order, or nil if the key isn’t present. For example:
+//
+//	records := multimap.FindAll(timestamp)
+func (me *SortedMultiMap[K, V]) FindAll(key K) []V {
+	if root := me.tree.findNode(key); root != nil {
+		return slices.Clone(root.value)
+	}
+	return nil
+}
+
+// All is a range function for use as an iterable in a
+// for … range loop that returns every key-value pair in key order,
+// including duplicate keys (whose values are returned in insertion
+// order), e.g.,
+//
+//	for key, value := range multimap.All()
+//
+// See also [Keys]
+func (me *SortedMultiMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for key, values := range me.tree.All() {
+			for _, value := range values {
+				if !yield(key, value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Keys is a range function for use as an iterable in a
+// for … range loop that returns each distinct key once:
+//
+//	for key := range multimap.Keys()
+//
+// See also [All]
+func (me *SortedMultiMap[K, V]) Keys() iter.Seq[K] {
+	return me.tree.Keys()
+}
+
+// Delete deletes all the values held for the given key and returns how
+// many were deleted (0 if the key isn’t present).
+// See also [DeleteOne] and [Clear]
+func (me *SortedMultiMap[K, V]) Delete(key K) int {
+	count := me.Count(key)
+	if count > 0 {
+		me.tree.Delete(key)
+		me.size -= count
+	}
+	return count
+}
+
+// DeleteOne deletes the oldest (first inserted) value held for the given
+// key and returns true, or does nothing and returns false if the key
+// isn’t present. The key itself is deleted along with its last value.
+// See also [Delete]
+func (me *SortedMultiMap[K, V]) DeleteOne(key K) bool {
+	root := me.tree.findNode(key)
+	if root == nil {
+		return false
+	}
+	if len(root.value) == 1 {
+		me.tree.Delete(key)
+	} else {
+		var zero V
+		root.value[0] = zero // don't keep the deleted value reachable
+		root.value = root.value[1:]
+	}
+	me.size--
+	return true
+}
+
+// Clear deletes all the multimap’s keys and values.
+// See also [Delete]
+func (me *SortedMultiMap[K, V]) Clear() {
+	me.tree.Clear()
+	me.size = 0
+}
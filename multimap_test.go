@@ -0,0 +1,95 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMultiMap(t *testing.T) {
+	var multimap SortedMultiMap[int, string]
+	for i, word := range strings.Fields("one two three four five six") {
+		multimap.Insert(len(word), word)
+		if multimap.Len() != i+1 {
+			t.Errorf("expected %d; got %d", i+1, multimap.Len())
+		}
+	}
+	if multimap.KeyCount() != 3 {
+		t.Errorf("expected 3 keys; got %d", multimap.KeyCount())
+	}
+	var out strings.Builder
+	for key, value := range multimap.All() {
+		out.WriteString(fmt.Sprintf("%d:%s ", key, value))
+	}
+	actual := strings.TrimSpace(out.String())
+	expected := "3:one 3:two 3:six 4:four 4:five 5:three"
+	if actual != expected {
+		t.Errorf("expected %q; got %q", expected, actual)
+	}
+	if keys := slices.Collect(multimap.Keys()); !slices.Equal(keys,
+		[]int{3, 4, 5}) {
+		t.Errorf("expected [3 4 5]; got %v", keys)
+	}
+	if words := multimap.FindAll(3); !slices.Equal(words,
+		[]string{"one", "two", "six"}) {
+		t.Errorf("expected [one two six]; got %v", words)
+	}
+	if words := multimap.FindAll(9); words != nil {
+		t.Errorf("expected nil; got %v", words)
+	}
+	if count := multimap.Count(4); count != 2 {
+		t.Errorf("expected 2; got %d", count)
+	}
+	if count := multimap.Count(9); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	words := multimap.FindAll(3)
+	words[0] = "changed"
+	if multimap.FindAll(3)[0] != "one" {
+		t.Error("expected FindAll to return a copy")
+	}
+	for key := range multimap.All() {
+		if key == 4 {
+			break
+		}
+	}
+}
+
+func TestMultiMapDelete(t *testing.T) {
+	var multimap SortedMultiMap[int, string]
+	for _, word := range strings.Fields("one two three four five six") {
+		multimap.Insert(len(word), word)
+	}
+	if !multimap.DeleteOne(3) {
+		t.Error("expected true; got false")
+	}
+	if words := multimap.FindAll(3); !slices.Equal(words,
+		[]string{"two", "six"}) {
+		t.Errorf("expected [two six]; got %v", words)
+	}
+	if multimap.Len() != 5 {
+		t.Errorf("expected 5; got %d", multimap.Len())
+	}
+	if !multimap.DeleteOne(5) || multimap.Contains(5) {
+		t.Error("expected key 5 to be deleted with its last value")
+	}
+	if multimap.DeleteOne(5) {
+		t.Error("expected false; got true")
+	}
+	if count := multimap.Delete(4); count != 2 {
+		t.Errorf("expected 2; got %d", count)
+	}
+	if count := multimap.Delete(4); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	if multimap.Len() != 2 || multimap.KeyCount() != 1 {
+		t.Errorf("expected 2 values for 1 key; got %d for %d",
+			multimap.Len(), multimap.KeyCount())
+	}
+	multimap.Clear()
+	if multimap.Len() != 0 || multimap.KeyCount() != 0 {
+		t.Errorf("expected empty; got %d", multimap.Len())
+	}
+}
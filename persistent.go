@@ -0,0 +1,291 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+// WithInsert returns a new SortedMap holding this tree’s key-value items
+// plus the given key and value (replacing the key’s value if it is
+// already present), and leaves this tree completely unchanged. For
+// example:
+//
+//	next := tree.WithInsert(key, value)
+//
+// Rather than copying the whole tree, WithInsert copies only the nodes on
+// the path from the root to the key (plus any nodes rebalancing
+// touches), so it is O(log n) in time and space, and the two trees share
+// all their other nodes. This makes it cheap to keep earlier versions of
+// a tree, e.g., for undo or for readers of a snapshot.
+//
+// Sharing is safe: if either tree is later changed in place (e.g., by
+// [Insert], or by [Delete] of a key that is present), it first makes a
+// private copy of its nodes, so the other tree is never affected. That
+// copy is O(n) but happens only once per tree. Since the copy replaces
+// the tree’s nodes, it invalidates any of the tree’s cursors (see
+// [Cursor]), even if the change only replaces a value. Pointers returned
+// by [FindRef] before WithInsert is called point into nodes that are
+// then shared, so they must not be used afterwards: call FindRef again.
+//
+// WithInsert records in this tree that its nodes are shared, unless they
+// already are (e.g., if this tree was itself returned by WithInsert or
+// [WithDelete]). So the first version taken from a tree must not be taken
+// concurrently with any other use of the tree, although after that any
+// number of goroutines may take versions of it and read it concurrently.
+//
+// The new tree has the same duplicate-key policy as this one but no
+// hooks, and calling WithInsert calls none of this tree’s hooks.
+func (me *SortedMap[K, V]) WithInsert(key K, value V) *SortedMap[K, V] {
+	tree := &SortedMap[K, V]{size: me.size, onDuplicate: me.onDuplicate,
+		shared: true}
+	tree.root = tree.insertCopy(me.root, key, value)
+	tree.root.red = false
+	me.markShared()
+	return tree
+}
+
+// WithDelete returns a new SortedMap holding this tree’s key-value items
+// except the one with the given key, and leaves this tree completely
+// unchanged (so it still holds the key and has the same size). If the key
+// isn’t in the tree, the new tree simply shares all of this tree’s nodes.
+// For example:
+//
+//	next := tree.WithDelete(key)
+//
+// Like [WithInsert], this copies only the O(log n) nodes on the path to
+// the key (plus any nodes rebalancing touches) and shares the rest, with
+// the same sharing semantics and the same caveats about cursors, FindRef
+// pointers, and concurrency.
+func (me *SortedMap[K, V]) WithDelete(key K) *SortedMap[K, V] {
+	tree := &SortedMap[K, V]{root: me.root, size: me.size,
+		onDuplicate: me.onDuplicate, shared: me.root != nil}
+	if me.findNode(key) == nil {
+		me.markShared()
+		return tree
+	}
+	root := copyNode(me.root)
+	if !isRed(root.left) && !isRed(root.right) {
+		root.red = true
+	}
+	if tree.root = deleteCopy(root, key); tree.root != nil {
+		tree.root.red = false
+	}
+	tree.size--
+	tree.shared = tree.root != nil
+	me.markShared()
+	return tree
+}
+
+// markShared records that the tree’s nodes (if any) are shared with
+// another tree. It only writes to the tree if they weren’t already
+// shared, so that versions of a shared tree may be taken concurrently.
+func (me *SortedMap[K, V]) markShared() {
+	if !me.shared && me.root != nil {
+		me.shared = true
+	}
+}
+
+// SharedNodeCount returns how many nodes the a and b trees share (by
+// identity rather than by equality), e.g., to confirm that [WithInsert]
+// and [WithDelete] share most of their nodes with the original tree. For
+// example:
+//
+//	next := tree.WithInsert(key, value)
+//	shared := SharedNodeCount(tree, next) // all but O(log n) nodes
+//
+// SharedNodeCount is O(n) and is intended for diagnostics (e.g., in tests
+// and benchmarks) rather than for hot paths.
+func SharedNodeCount[K Ordered, V any](a, b *SortedMap[K, V]) int {
+	nodes := make(map[*node[K, V]]struct{}, a.size)
+	var stack inOrder[K, V]
+	stack.pushLeft(a.root)
+	for root := stack.next(); root != nil; root = stack.next() {
+		nodes[root] = struct{}{}
+	}
+	return sharedNodeCount(b.root, nodes)
+}
+
+// sharedNodeCount returns how many of the root’s subtree’s nodes are in
+// nodes. Since a shared node’s children are also shared, the whole of a
+// shared node’s subtree is counted without being visited.
+func sharedNodeCount[K Ordered, V any](root *node[K, V],
+	nodes map[*node[K, V]]struct{},
+) int {
+	if root == nil {
+		return 0
+	}
+	if _, ok := nodes[root]; ok {
+		return root.size
+	}
+	return sharedNodeCount(root.left, nodes) +
+		sharedNodeCount(root.right, nodes)
+}
+
+// own makes the tree the sole owner of its nodes by copying them if they
+// may be shared with another tree (see [WithInsert]). Every method that
+// changes nodes in place must call own first, but only once it knows that
+// something will change (e.g., using findOwnedNode), so that no-ops don’t
+// copy the whole tree.
+func (me *SortedMap[K, V]) own() {
+	if me.shared {
+		me.root = mapValues(me.root, func(_ K, value V) V { return value })
+		me.shared = false
+	}
+}
+
+// findOwnedNode returns the node with the given key (or nil if there is
+// none) ready to be changed in place, i.e., after calling own if the key
+// is present.
+func (me *SortedMap[K, V]) findOwnedNode(key K) *node[K, V] {
+	root := me.findNode(key)
+	if root != nil && me.shared {
+		me.own()
+		root = me.findNode(key)
+	}
+	return root
+}
+
+// insertCopy is like insert, but copies every node it changes rather
+// than changing it in place.
+func (me *SortedMap[K, V]) insertCopy(root *node[K, V], key K,
+	value V,
+) *node[K, V] {
+	if root == nil {
+		me.size++
+		return &node[K, V]{key: key, value: value, red: true, size: 1}
+	}
+	root = copyNode(root)
+	if key < root.key {
+		root.left = me.insertCopy(root.left, key, value)
+	} else if key > root.key {
+		root.right = me.insertCopy(root.right, key, value)
+	} else {
+		root.value = value
+	}
+	resize(root)
+	if isRed(root.right) && !isRed(root.left) {
+		root = rotateLeftCopy(root)
+	}
+	if isRed(root.left) && isRed(root.left.left) {
+		root = rotateRightCopy(root)
+	}
+	if isRed(root.left) && isRed(root.right) {
+		colorFlipCopy(root)
+	}
+	return root
+}
+
+// deleteCopy is like delete_, but copies every node it changes rather
+// than changing it in place. The root must already have been copied.
+func deleteCopy[K Ordered, V any](root *node[K, V], key K) *node[K, V] {
+	if key < root.key {
+		if root.left != nil {
+			if !isRed(root.left) && !isRed(root.left.left) {
+				root = moveRedLeftCopy(root)
+			} else {
+				root.left = copyNode(root.left)
+			}
+			root.left = deleteCopy(root.left, key)
+		}
+	} else {
+		if isRed(root.left) {
+			root = rotateRightCopy(root)
+		}
+		if key == root.key && root.right == nil {
+			return nil
+		}
+		if root.right != nil {
+			if !isRed(root.right) && !isRed(root.right.left) {
+				root = moveRedRightCopy(root)
+			} else {
+				root.right = copyNode(root.right)
+			}
+			if key == root.key {
+				smallest := first(root.right)
+				root.key = smallest.key
+				root.value = smallest.value
+				root.right = deleteMinimumCopy(root.right)
+			} else {
+				root.right = deleteCopy(root.right, key)
+			}
+		}
+	}
+	return fixUpCopy(root)
+}
+
+// deleteMinimumCopy is like deleteMinimum, but copies every node it
+// changes. The root must already have been copied.
+func deleteMinimumCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	if root.left == nil {
+		return nil
+	}
+	if !isRed(root.left) && !isRed(root.left.left) {
+		root = moveRedLeftCopy(root)
+	} else {
+		root.left = copyNode(root.left)
+	}
+	root.left = deleteMinimumCopy(root.left)
+	return fixUpCopy(root)
+}
+
+// copyNode returns a copy of the node (or nil if it is nil).
+func copyNode[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	if root == nil {
+		return nil
+	}
+	copied := *root
+	return &copied
+}
+
+// The …Copy rotations and color flip must only be given a node that has
+// already been copied; they copy the children they change.
+
+func rotateLeftCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	root.right = copyNode(root.right)
+	return rotateLeft(root)
+}
+
+func rotateRightCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	root.left = copyNode(root.left)
+	return rotateRight(root)
+}
+
+func colorFlipCopy[K Ordered, V any](root *node[K, V]) {
+	root.left = copyNode(root.left)
+	root.right = copyNode(root.right)
+	colorFlip(root)
+}
+
+// moveRedLeftCopy is like moveRedLeft but copies the nodes it changes;
+// afterwards the returned root’s children have all been copied.
+func moveRedLeftCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	colorFlipCopy(root)
+	if root.right != nil && isRed(root.right.left) {
+		root.right = rotateRightCopy(root.right)
+		root = rotateLeftCopy(root)
+		colorFlipCopy(root)
+	}
+	return root
+}
+
+// moveRedRightCopy is like moveRedRight but copies the nodes it changes;
+// afterwards the returned root’s children have all been copied.
+func moveRedRightCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	colorFlipCopy(root)
+	if root.left != nil && isRed(root.left.left) {
+		root = rotateRightCopy(root)
+		colorFlipCopy(root)
+	}
+	return root
+}
+
+func fixUpCopy[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	resize(root)
+	if isRed(root.right) {
+		root = rotateLeftCopy(root)
+	}
+	if isRed(root.left) && isRed(root.left.left) {
+		root = rotateRightCopy(root)
+	}
+	if isRed(root.left) && isRed(root.right) {
+		colorFlipCopy(root)
+	}
+	return root
+}
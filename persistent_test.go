@@ -0,0 +1,266 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import (
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestWithInsert(t *testing.T) {
+	var empty SortedMap[int, int]
+	one := empty.WithInsert(1, 10)
+	if empty.Len() != 0 || one.Len() != 1 {
+		t.Errorf("expected 0 1; got %d %d", empty.Len(), one.Len())
+	}
+	versions := []*SortedMap[int, int]{one}
+	shadows := []map[int]int{{1: 10}}
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for range 500 {
+		i := rnd.IntN(len(versions))
+		key, value := rnd.IntN(200), rnd.Int()
+		versions = append(versions, versions[i].WithInsert(key, value))
+		shadow := maps.Clone(shadows[i])
+		shadow[key] = value
+		shadows = append(shadows, shadow)
+	}
+	for i, tree := range versions {
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("version %d: %s", i, err)
+		}
+		if tree.Len() != len(shadows[i]) {
+			t.Fatalf("version %d: expected %d; got %d", i,
+				len(shadows[i]), tree.Len())
+		}
+		for key, value := range tree.All() {
+			if shadows[i][key] != value {
+				t.Fatalf("version %d: expected %d=%d; got %d", i, key,
+					shadows[i][key], value)
+			}
+		}
+	}
+}
+
+func TestWithInsertThenMutate(t *testing.T) {
+	var tree SortedMap[int, int]
+	tree.Reserve(100)
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	next := tree.WithInsert(50, -50)
+	next.Insert(100, 100)
+	next.Delete(0)
+	ref, _ := next.FindRef(1)
+	*ref = -1
+	tree.ReplaceValue(2, -2)
+	tree.Clear() // must not recycle nodes that next shares
+	for i := range 100 {
+		tree.Insert(i, 0)
+	}
+	if err := next.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	expected := map[int]int{1: -1, 2: 2, 50: -50, 99: 99, 100: 100}
+	for key, value := range expected {
+		if got, ok := next.Find(key); !ok || got != value {
+			t.Errorf("expected %d=%d; got %d %t", key, value, got, ok)
+		}
+	}
+	if next.Contains(0) || next.Len() != 100 {
+		t.Errorf("expected 100 items without 0; got %d", next.Len())
+	}
+}
+
+func TestWithDelete(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	next := tree.WithDelete(50)
+	if !tree.Contains(50) || tree.Len() != 100 {
+		t.Errorf("expected the original to keep 50; got %t %d",
+			tree.Contains(50), tree.Len())
+	}
+	if next.Contains(50) || next.Len() != 99 {
+		t.Errorf("expected 50 deleted; got %t %d", next.Contains(50),
+			next.Len())
+	}
+	if same := next.WithDelete(50); same.Len() != 99 || same.root !=
+		next.root {
+		t.Errorf("expected a missing key to share the whole tree")
+	}
+	versions := []*SortedMap[int, int]{&tree}
+	shadows := []map[int]int{maps.Collect(tree.All())}
+	rnd := rand.New(rand.NewPCG(3, 4))
+	for range 1000 {
+		i := rnd.IntN(len(versions))
+		key := rnd.IntN(120)
+		shadow := maps.Clone(shadows[i])
+		if rnd.IntN(3) == 0 {
+			versions = append(versions, versions[i].WithInsert(key, -key))
+			shadow[key] = -key
+		} else {
+			versions = append(versions, versions[i].WithDelete(key))
+			delete(shadow, key)
+		}
+		shadows = append(shadows, shadow)
+	}
+	versions[1].Delete(1) // must copy rather than change shared nodes
+	delete(shadows[1], 1)
+	for i, tree := range versions {
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("version %d: %s", i, err)
+		}
+		if got := maps.Collect(tree.All()); !maps.Equal(got, shadows[i]) {
+			t.Fatalf("version %d: expected %v; got %v", i, shadows[i], got)
+		}
+		if tree.Len() != len(shadows[i]) {
+			t.Fatalf("version %d: expected %d; got %d", i,
+				len(shadows[i]), tree.Len())
+		}
+	}
+	var empty SortedMap[int, int]
+	if next := empty.WithInsert(1, 1).WithDelete(1); next.Len() != 0 ||
+		next.root != nil {
+		t.Errorf("expected an empty tree; got %d", next.Len())
+	}
+}
+
+func TestSharedNodeCount(t *testing.T) {
+	var tree SortedMap[int, int]
+	if count := SharedNodeCount(&tree, &tree); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	for i := range 1000 {
+		tree.Insert(i, i)
+	}
+	if count := SharedNodeCount(&tree, &tree); count != 1000 {
+		t.Errorf("expected 1000; got %d", count)
+	}
+	if count := SharedNodeCount(&tree, tree.Clone()); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	maxCopied := 3 * tree.Height() // path plus siblings rebalancing copies
+	for _, next := range []*SortedMap[int, int]{tree.WithInsert(500, 0),
+		tree.WithInsert(1000, 0), tree.WithDelete(0), tree.WithDelete(999),
+	} {
+		if count := SharedNodeCount(&tree, next); count < 1000-maxCopied {
+			t.Errorf("expected at least %d shared; got %d",
+				1000-maxCopied, count)
+		}
+		if a, b := SharedNodeCount(&tree, next),
+			SharedNodeCount(next, &tree); a != b {
+			t.Errorf("expected %d == %d", a, b)
+		}
+	}
+	next := tree.WithDelete(-1) // not present so shares everything
+	if count := SharedNodeCount(&tree, next); count != 1000 {
+		t.Errorf("expected 1000; got %d", count)
+	}
+	next.Insert(-1, -1) // copies before changing
+	if count := SharedNodeCount(&tree, next); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+}
+
+func TestWithInsertFindRef(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	stale, _ := tree.FindRef(0)
+	next := tree.WithInsert(100, 100)
+	if stale, _ := next.FindRef(0); stale == nil {
+		t.Fatal("expected a pointer")
+	}
+	ref, _ := tree.FindRef(0) // must be fetched again after WithInsert
+	if ref == stale {
+		t.Error("expected FindRef to return a pointer to a copied node")
+	}
+	*ref = 999
+	if value, _ := next.Find(0); value != 0 {
+		t.Errorf("expected the new version to be unchanged; got %d", value)
+	}
+	if value, _ := tree.Find(0); value != 999 {
+		t.Errorf("expected 999; got %d", value)
+	}
+}
+
+func TestSharedCursor(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 10 {
+		tree.Insert(i, i)
+	}
+	cursor := tree.Cursor()
+	cursor.Next()
+	_ = tree.WithInsert(10, 10)
+	tree.ReplaceValue(0, 7) // copies the nodes, invalidating the cursor
+	if !cursor.Seek(0) || cursor.Value() != 7 {
+		t.Errorf("expected a repositioned cursor to see 7; got %d",
+			cursor.Value())
+	}
+	tree.ReplaceValue(0, 8) // no longer shared so the cursor stays valid
+	if cursor.Value() != 8 {
+		t.Errorf("expected 8; got %d", cursor.Value())
+	}
+}
+
+func TestSharedNoOps(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	next := tree.WithInsert(100, 100)
+	shared := SharedNodeCount(&tree, next)
+	for _, noOp := range []func() bool{
+		func() bool { return tree.Delete(-1) },
+		func() bool { return tree.InsertIfAbsent(5, -5) },
+		func() bool { return tree.ReplaceValue(-1, 0) },
+		func() bool { return tree.Swap(1, -1) },
+		func() bool {
+			return tree.CompareAndSwapValue(2, -2, 0,
+				func(a, b int) bool { return a == b })
+		},
+		func() bool { _, ok := tree.FindRef(-1); return ok },
+	} {
+		if noOp() {
+			t.Error("expected nothing to change")
+		}
+		if count := SharedNodeCount(&tree, next); count != shared {
+			t.Errorf("expected %d shared nodes; got %d", shared, count)
+		}
+	}
+	tree.Delete(5)
+	if count := SharedNodeCount(&tree, next); count != 0 {
+		t.Errorf("expected no shared nodes; got %d", count)
+	}
+}
+
+// Run with -race: taking versions of a shared tree mustn’t write to it.
+func TestWithInsertConcurrent(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	snapshot := tree.WithInsert(100, 100)
+	versions := make([]*SortedMap[int, int], 8)
+	var wg sync.WaitGroup
+	for i := range versions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			versions[i] = snapshot.WithInsert(-i-1, i).WithDelete(i)
+			snapshot.Find(i)
+		}()
+	}
+	wg.Wait()
+	for i, version := range versions {
+		if version.Len() != 101 || !version.Contains(-i-1) ||
+			version.Contains(i) {
+			t.Errorf("version %d: unexpected contents %v", i,
+				version.KeysSlice())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import "iter"
+
+// A ReadOnlyMap is a view of a SortedMap that provides lookups and
+// iteration but no methods for changing the tree. Create one with
+// [SortedMap.Freeze]. For example:
+//
+//	func NewLookup() ReadOnlyMap[string, int] {
+//		var table SortedMap[string, int]
+//		// … populate table …
+//		return table.Freeze()
+//	}
+//
+// A ReadOnlyMap shares its tree rather than copying it, so creating one
+// is O(1). The view only prevents changes made through it: the original
+// SortedMap remains mutable, and any changes made to it are visible
+// through the view.
+type ReadOnlyMap[K Ordered, V any] struct {
+	tree *SortedMap[K, V]
+}
+
+// Freeze returns a read-only view of the tree.
+func (me *SortedMap[K, V]) Freeze() ReadOnlyMap[K, V] {
+	return ReadOnlyMap[K, V]{me}
+}
+
+// Len returns the number of items in the tree.
+func (me ReadOnlyMap[K, V]) Len() int { return me.tree.Len() }
+
+// Contains returns true if the key is in the tree and false otherwise.
+func (me ReadOnlyMap[K, V]) Contains(key K) bool {
+	return me.tree.Contains(key)
+}
+
+// Find returns the value with the given key and true, or V’s zero value
+// and false if the key isn’t in the tree.
+func (me ReadOnlyMap[K, V]) Find(key K) (V, bool) {
+	return me.tree.Find(key)
+}
+
+// All is a range function that returns the tree’s keys and values in key
+// order. See [SortedMap.All].
+func (me ReadOnlyMap[K, V]) All() iter.Seq2[K, V] { return me.tree.All() }
+
+// Keys is a range function that returns the tree’s keys in order. See
+// [SortedMap.Keys].
+func (me ReadOnlyMap[K, V]) Keys() iter.Seq[K] { return me.tree.Keys() }
+
+// Values is a range function that returns the tree’s values in key order.
+// See [SortedMap.Values].
+func (me ReadOnlyMap[K, V]) Values() iter.Seq[V] {
+	return me.tree.Values()
+}
+
+// Range is a range function that returns the tree’s keys and values for
+// keys in the half-open range [lo, hi). See [SortedMap.Range].
+func (me ReadOnlyMap[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+	return me.tree.Range(lo, hi)
+}
+
+// RangeKeys is a range function that returns the tree’s keys in the
+// half-open range [lo, hi). See [SortedMap.RangeKeys].
+func (me ReadOnlyMap[K, V]) RangeKeys(lo, hi K) iter.Seq[K] {
+	return me.tree.RangeKeys(lo, hi)
+}
+
+// RangeValues is a range function that returns the tree’s values for
+// keys in the half-open range [lo, hi). See [SortedMap.RangeValues].
+func (me ReadOnlyMap[K, V]) RangeValues(lo, hi K) iter.Seq[V] {
+	return me.tree.RangeValues(lo, hi)
+}
+
+// RangeBackward is a range function that returns the tree’s keys and
+// values for keys in the half-open range [lo, hi) in descending key
+// order. See [SortedMap.RangeBackward].
+func (me ReadOnlyMap[K, V]) RangeBackward(lo, hi K) iter.Seq2[K, V] {
+	return me.tree.RangeBackward(lo, hi)
+}
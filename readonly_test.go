@@ -0,0 +1,62 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("d b a c e") {
+		tree.Insert(word, i)
+	}
+	frozen := tree.Freeze()
+	if frozen.Len() != 5 || !frozen.Contains("a") || frozen.Contains("z") {
+		t.Error("expected 5 items including a but not z")
+	}
+	if value, ok := frozen.Find("c"); !ok || value != 3 {
+		t.Errorf("expected 3; got %d", value)
+	}
+	if keys := strings.Join(slices.Collect(frozen.Keys()), ""); keys !=
+		"abcde" {
+		t.Errorf("expected abcde; got %q", keys)
+	}
+	if values := slices.Collect(frozen.Values()); !slices.Equal(values,
+		tree.ValuesSlice()) {
+		t.Errorf("expected %v; got %v", tree.ValuesSlice(), values)
+	}
+	count := 0
+	for key, value := range frozen.All() {
+		if tree.GetOr(key, -1) != value {
+			t.Errorf("expected %d; got %d", tree.GetOr(key, -1), value)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("expected 5; got %d", count)
+	}
+	if keys := strings.Join(slices.Collect(frozen.RangeKeys("b", "d")),
+		""); keys != "bc" {
+		t.Errorf("expected bc; got %q", keys)
+	}
+	if values := slices.Collect(frozen.RangeValues("b", "d")); !slices.Equal(
+		values, []int{1, 3}) {
+		t.Errorf("expected [1 3]; got %v", values)
+	}
+	var keys []string
+	for key := range frozen.Range("c", "z") {
+		keys = append(keys, key)
+	}
+	for key := range frozen.RangeBackward("c", "z") {
+		keys = append(keys, key)
+	}
+	if joined := strings.Join(keys, ""); joined != "cdeedc" {
+		t.Errorf("expected cdeedc; got %q", joined)
+	}
+	tree.Insert("f", 5)
+	if frozen.Len() != 6 || !frozen.Contains("f") {
+		t.Error("expected changes to the tree to be visible")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import "iter"
+
+// A SortedSet is a < ordered set of keys. It is built on a [SortedMap]
+// whose values are the zero-size struct{}, so it needs no memory for
+// values.
+//
+// A SortedSet zero value is usable.
+// Create it with statements like these:
+//
+//	var set SortedSet[string]
+//	set := SortedSet[int]{}
+type SortedSet[K Ordered] struct {
+	tree SortedMap[K, struct{}]
+}
+
+// Add adds the key to the set and returns true, or does nothing and
+// returns false if the key is already in the set.
+func (me *SortedSet[K]) Add(key K) bool {
+	return me.tree.InsertIfAbsent(key, struct{}{})
+}
+
+// Remove removes the key from the set and returns true, or does nothing
+// and returns false if the key isn’t in the set.
+func (me *SortedSet[K]) Remove(key K) bool { return me.tree.Delete(key) }
+
+// Contains returns true if the key is in the set and false otherwise.
+func (me *SortedSet[K]) Contains(key K) bool {
+	return me.tree.Contains(key)
+}
+
+// Len returns the number of keys in the set.
+func (me *SortedSet[K]) Len() int { return me.tree.Len() }
+
+// All is a range function for use as an iterable in a for … range loop
+// that returns all of the set’s keys in order:
+//
+//	for key := range set.All()
+func (me *SortedSet[K]) All() iter.Seq[K] { return me.tree.Keys() }
+
+// Union returns a new SortedSet containing the keys that are in this set
+// or the other set (or both). This is O(n+m).
+func (me *SortedSet[K]) Union(other *SortedSet[K]) *SortedSet[K] {
+	return &SortedSet[K]{*me.tree.Union(&other.tree, nil)}
+}
+
+// Intersection returns a new SortedSet containing the keys that are in
+// both this set and the other set. This is O(n+m).
+func (me *SortedSet[K]) Intersection(other *SortedSet[K]) *SortedSet[K] {
+	var items []Item[K, struct{}]
+	for key := range me.tree.IntersectionKeys(&other.tree) {
+		items = append(items, Item[K, struct{}]{Key: key})
+	}
+	return &SortedSet[K]{*fromSorted(items)}
+}
+
+// Difference returns a new SortedSet containing the keys that are in
+// this set but not in the other set. This is O(n+m).
+func (me *SortedSet[K]) Difference(other *SortedSet[K]) *SortedSet[K] {
+	return &SortedSet[K]{*me.tree.Difference(&other.tree)}
+}
@@ -0,0 +1,59 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	var set SortedSet[int]
+	for _, key := range []int{5, 3, 9, 1, 3, 7} {
+		set.Add(key)
+	}
+	if set.Len() != 5 {
+		t.Errorf("expected 5; got %d", set.Len())
+	}
+	if set.Add(9) || !set.Add(11) {
+		t.Error("expected 9 to be present and 11 to be added")
+	}
+	if !set.Remove(11) || set.Remove(11) || set.Contains(11) {
+		t.Error("expected 11 to be removed once")
+	}
+	if !set.Contains(1) || set.Contains(2) {
+		t.Error("expected 1 but not 2")
+	}
+	if keys := slices.Collect(set.All()); !slices.Equal(keys,
+		[]int{1, 3, 5, 7, 9}) {
+		t.Errorf("expected [1 3 5 7 9]; got %v", keys)
+	}
+	for range set.All() {
+		break
+	}
+}
+
+func TestSortedSetOperations(t *testing.T) {
+	var a, b SortedSet[int]
+	for i := range 10 {
+		a.Add(i)
+		b.Add(i + 5)
+	}
+	check := func(what string, set *SortedSet[int], expected []int) {
+		t.Helper()
+		if err := set.tree.CheckInvariants(); err != nil {
+			t.Errorf("%s: %v", what, err)
+		}
+		if keys := slices.Collect(set.All()); !slices.Equal(keys,
+			expected) || set.Len() != len(expected) {
+			t.Errorf("%s: expected %v; got %v", what, expected, keys)
+		}
+	}
+	check("union", a.Union(&b), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10,
+		11, 12, 13, 14})
+	check("intersection", a.Intersection(&b), []int{5, 6, 7, 8, 9})
+	check("difference", a.Difference(&b), []int{0, 1, 2, 3, 4})
+	var empty SortedSet[int]
+	check("empty intersection", a.Intersection(&empty), nil)
+	a.Add(100)
+	check("unchanged", &b, []int{5, 6, 7, 8, 9, 10, 11, 12, 13, 14})
+}
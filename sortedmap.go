@@ -7,67 +7,305 @@
 package sortedmap
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
 	"iter"
+	"math"
+	"math/bits"
+	"strings"
 
 	"github.com/mark-summerfield/unum"
 )
 
+// Ordered is the constraint for the keys of a SortedMap: any type that
+// supports < (i.e., strings, integers, and floats, and types based on
+// them). Float keys must not be NaN, since a NaN is neither less than,
+// greater than, nor equal to any key (including itself) and so can never
+// be found.
+type Ordered = cmp.Ordered
+
+// Comparable is the constraint this package used for keys before
+// [Ordered]: strings and integers. Since these are a subset of the
+// Ordered types, code written in terms of Comparable still works.
+//
+// Deprecated: Use Ordered, which also allows floats and which isn’t
+// easily confused with Go’s built-in comparable constraint.
 type Comparable = unum.Comparable
 
+type Integer = unum.Integer
+
+type Number = unum.Number
+
+// ErrOverlap is returned by [Join] if its trees’ keys are not disjoint
+// and ordered.
+var ErrOverlap = errors.New("first tree's keys must all be less than " +
+	"the second tree's keys")
+
+// ErrDuplicateKey is wrapped by the error returned by [InsertChecked] if
+// the tree’s policy is [DuplicateError] and the key is already present.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// ErrNaNKey is wrapped by the error returned by a decoding method such as
+// [UnmarshalText], [ReadCSV], or [DecodeJSON] if it reads a NaN key (see
+// [Ordered]).
+var ErrNaNKey = errors.New("NaN key")
+
+// ErrLengthMismatch is returned by [InsertPairs] if it is given different
+// numbers of keys and values.
+var ErrLengthMismatch = errors.New("keys and values must have the same " +
+	"length")
+
 // An SortedMap zero value is usable.
 // Create it with statements like these:
 //
 //	var tree SortedMap[string, int]
 //	tree := SortedMap[int, int]{}
-type SortedMap[K Comparable, V any] struct {
-	root *node[K, V]
-	size int
+type SortedMap[K Ordered, V any] struct {
+	root   *node[K, V]
+	size   int
+	pool   []node[K, V]  // preallocated nodes not yet used; see Reserve
+	free   []*node[K, V] // nodes recycled by Clear if pooled
+	pooled bool
+	// what InsertChecked does with existing keys; see NewWithPolicy
+	onDuplicate OnDuplicate
+	hooks       *Hooks[K, V] // nil unless set by SetHooks
+	shared      bool         // nodes may be shared; see WithInsert
 }
 
-type node[K Comparable, V any] struct {
+// OnDuplicate is a policy for what [InsertChecked] does when the key is
+// already in the tree.
+type OnDuplicate uint8
+
+const (
+	DuplicateOverwrite OnDuplicate = iota // replace the value (default)
+	DuplicateKeep                         // keep the existing value
+	DuplicateError                        // keep it and return an error
+)
+
+// NewWithPolicy returns a new empty tree whose [InsertChecked] method
+// handles keys that are already present according to the given policy.
+// (A zero value SortedMap has the [DuplicateOverwrite] policy.) For
+// example, a strict loader might use:
+//
+//	tree := sortedmap.NewWithPolicy[string, int](sortedmap.DuplicateError)
+func NewWithPolicy[K Ordered, V any](
+	policy OnDuplicate,
+) *SortedMap[K, V] {
+	return &SortedMap[K, V]{onDuplicate: policy}
+}
+
+type node[K Ordered, V any] struct {
 	key         K
 	value       V
 	red         bool
+	size        int // number of nodes in this subtree (including this one)
 	left, right *node[K, V]
 }
 
-// Insert inserts a new key-value item into the tree and
-// returns true; or replaces an existing key-value pair’s
-// value if the keys are equal and returns false. For example:
+// An Item holds a key-value pair.
+type Item[K Ordered, V any] struct {
+	Key   K
+	Value V
+}
+
+// Insert inserts a new key-value item into the tree and returns false;
+// or replaces an existing key-value pair’s value if the keys are equal
+// and returns true. (This is the opposite of [InsertIfAbsent], which
+// returns true if the key is new.) [SortedMapKeyed.Insert] returns the
+// same. For example:
 //
-//	ok := tree.Insert(key, value).
+//	replaced := tree.Insert(key, value)
+//
+// Insert is recursive, but since the tree is always balanced, even for
+// sorted input, the recursion depth is bounded by the tree’s height which
+// is at most 2·log₂(n+1) (e.g., 60 for a billion items).
 func (me *SortedMap[K, V]) Insert(key K, value V) bool {
 	size := me.size
-	me.root = me.insert(me.root, key, value)
-	me.root.red = false
+	me.put(key, value, replaceValue)
 	return size == me.size
 }
 
+// InsertIfAbsent inserts a new key-value item into the tree and returns
+// true if the key isn’t already in the tree; otherwise it leaves the
+// existing value unchanged and returns false. For example:
+//
+//	ok := tree.InsertIfAbsent(key, value) // first writer wins
+//
+// This takes a single descent of the tree.
+// See also [Insert]
+func (me *SortedMap[K, V]) InsertIfAbsent(key K, value V) bool {
+	size := me.size
+	me.put(key, value, nil)
+	return size != me.size
+}
+
+// InsertChecked inserts a new key-value item into the tree and returns
+// nil. If the key is already in the tree, then depending on the policy
+// given to [NewWithPolicy], it replaces the existing value
+// ([DuplicateOverwrite], the default) or leaves it unchanged
+// ([DuplicateKeep]) and returns nil, or leaves it unchanged and returns
+// an error wrapping [ErrDuplicateKey] ([DuplicateError]). For example:
+//
+//	if err := tree.InsertChecked(key, value); err != nil {
+//		return fmt.Errorf("line %d: %w", lino, err)
+//	}
+//
+// This takes a single descent of the tree.
+// See also [Insert] and [InsertIfAbsent]
+func (me *SortedMap[K, V]) InsertChecked(key K, value V) error {
+	var merge func(old, incoming V) V
+	if me.onDuplicate == DuplicateOverwrite {
+		merge = replaceValue
+	}
+	size := me.size
+	me.put(key, value, merge)
+	if size == me.size && me.onDuplicate == DuplicateError {
+		return fmt.Errorf("%w: %v", ErrDuplicateKey, key)
+	}
+	return nil
+}
+
+// InsertWith inserts a new key-value item into the tree if the key isn’t
+// already in the tree; otherwise it replaces the existing value with
+// merge(existingValue, value). Either way it returns the value now stored
+// for the key. For example:
+//
+//	total := tree.InsertWith(word, 1, func(old, incoming int) int {
+//		return old + incoming
+//	})
+//
+// This takes a single descent of the tree.
+// See also [Insert] and [InsertIfAbsent]
+func (me *SortedMap[K, V]) InsertWith(key K, value V,
+	merge func(old, incoming V) V,
+) V {
+	stored := value
+	me.put(key, value, func(old, incoming V) V {
+		stored = merge(old, incoming)
+		return stored
+	})
+	return stored
+}
+
+// replaceValue is the merge function [Insert] uses.
+func replaceValue[V any](_, incoming V) V { return incoming }
+
+// put inserts the key and value using insert, keeps the root black, and
+// calls any OnInsert or OnReplace hook.
+func (me *SortedMap[K, V]) put(key K, value V,
+	merge func(old, incoming V) V,
+) {
+	if merge == nil && me.shared && me.findNode(key) != nil {
+		return // nothing would change, so keep sharing the nodes
+	}
+	me.own()
+	if me.hooks == nil || merge == nil {
+		size := me.size
+		me.root = me.insert(me.root, key, value, merge)
+		me.root.red = false
+		if me.size != size {
+			me.hooks.inserted(key, value)
+		}
+		return
+	}
+	replaced := false
+	var oldValue, newValue V
+	me.root = me.insert(me.root, key, value, func(old, incoming V) V {
+		replaced, oldValue, newValue = true, old, merge(old, incoming)
+		return newValue
+	})
+	me.root.red = false
+	if replaced {
+		me.hooks.replaced(key, oldValue, newValue)
+	} else {
+		me.hooks.inserted(key, value)
+	}
+}
+
+// insert inserts a new node for the key and value, or if the key is
+// already present, replaces its value with merge(existing, value) (or
+// leaves it unchanged if merge is nil).
+//
+// An iterative version of insert (walking down while recording the path
+// in a fixed-size array and then rebalancing on the way back up) was
+// benchmarked and found to be no faster.
+//
+// [SortedMapKeyed] has its own copy of this balancing code (and of
+// delete_’s) in keyed.go, which must be kept in step with this one.
 func (me *SortedMap[K, V]) insert(root *node[K, V], key K,
-	value V,
+	value V, merge func(old, incoming V) V,
 ) *node[K, V] {
 	if root == nil { // If key was present it would go here
 		me.size++
-		return &node[K, V]{key: key, value: value, red: true}
-	}
-	if isRed(root.left) && isRed(root.right) {
-		colorFlip(root)
+		return me.newNode(key, value)
 	}
 	if key < root.key {
-		root.left = me.insert(root.left, key, value)
+		root.left = me.insert(root.left, key, value, merge)
 	} else if key > root.key {
-		root.right = me.insert(root.right, key, value)
-	} else { // Key already in tree so just replace value
-		root.value = value
+		root.right = me.insert(root.right, key, value, merge)
+	} else if merge != nil { // Key already in tree so just update value
+		root.value = merge(root.value, value)
 	}
+	resize(root)
 	return insertRotation(root)
 }
 
-func isRed[K Comparable, V any](root *node[K, V]) bool {
+// newNode returns a new red node of size 1 holding the key and value,
+// taking it from the recycled nodes or the preallocated pool if possible.
+func (me *SortedMap[K, V]) newNode(key K, value V) *node[K, V] {
+	var root *node[K, V]
+	if n := len(me.free); n > 0 {
+		root = me.free[n-1]
+		me.free = me.free[:n-1]
+	} else if len(me.pool) > 0 {
+		root = &me.pool[0]
+		me.pool = me.pool[1:]
+	} else {
+		return &node[K, V]{key: key, value: value, red: true, size: 1}
+	}
+	*root = node[K, V]{key: key, value: value, red: true, size: 1}
+	return root
+}
+
+// Reserve ensures that at least n more items can be inserted without
+// allocating nodes one at a time, by allocating any extra nodes needed in
+// a single block. It also makes [Clear] recycle the cleared nodes for
+// reuse rather than leave them for the garbage collector (until
+// [Release] is called). This reduces
+// allocations and GC pressure when inserting many items, or when a tree
+// is repeatedly filled and cleared. For example:
+//
+//	tree.Reserve(1_000_000)
+//
+// Note that a block of nodes is only garbage collected when none of its
+// nodes are in use, so deleting most of the items from a tree whose nodes
+// came from a block won’t free much memory.
+func (me *SortedMap[K, V]) Reserve(n int) {
+	me.pooled = true
+	if available := len(me.pool) + len(me.free); available < n {
+		me.pool = make([]node[K, V], n-len(me.free))
+	}
+}
+
+func isRed[K Ordered, V any](root *node[K, V]) bool {
 	return root != nil && root.red
 }
 
-func colorFlip[K Comparable, V any](root *node[K, V]) {
+func sizeOf[K Ordered, V any](root *node[K, V]) int {
+	if root == nil {
+		return 0
+	}
+	return root.size
+}
+
+// resize updates the root’s size from its children’s sizes; it must be
+// called whenever a node’s children change.
+func resize[K Ordered, V any](root *node[K, V]) {
+	root.size = 1 + sizeOf(root.left) + sizeOf(root.right)
+}
+
+func colorFlip[K Ordered, V any](root *node[K, V]) {
 	root.red = !root.red
 	if root.left != nil {
 		root.left.red = !root.left.red
@@ -77,7 +315,7 @@ func colorFlip[K Comparable, V any](root *node[K, V]) {
 	}
 }
 
-func insertRotation[K Comparable, V any](
+func insertRotation[K Ordered, V any](
 	root *node[K, V],
 ) *node[K, V] {
 	if isRed(root.right) && !isRed(root.left) {
@@ -86,10 +324,13 @@ func insertRotation[K Comparable, V any](
 	if isRed(root.left) && isRed(root.left.left) {
 		root = rotateRight(root)
 	}
+	if isRed(root.left) && isRed(root.right) {
+		colorFlip(root)
+	}
 	return root
 }
 
-func rotateLeft[K Comparable, V any](
+func rotateLeft[K Ordered, V any](
 	root *node[K, V],
 ) *node[K, V] {
 	x := root.right
@@ -97,10 +338,12 @@ func rotateLeft[K Comparable, V any](
 	x.left = root
 	x.red = root.red
 	root.red = true
+	x.size = root.size
+	resize(root)
 	return x
 }
 
-func rotateRight[K Comparable, V any](
+func rotateRight[K Ordered, V any](
 	root *node[K, V],
 ) *node[K, V] {
 	x := root.left
@@ -108,9 +351,217 @@ func rotateRight[K Comparable, V any](
 	x.right = root
 	x.red = root.red
 	root.red = true
+	x.size = root.size
+	resize(root)
 	return x
 }
 
+// InsertMany inserts every key-value pair from the given sequence (e.g.,
+// another map’s All()), replacing the values of any keys already in the
+// tree. For example:
+//
+//	tree.InsertMany(maps.All(m))
+//
+// See also [BulkLoadSorted]
+func (me *SortedMap[K, V]) InsertMany(items iter.Seq2[K, V]) {
+	for key, value := range items {
+		me.Insert(key, value)
+	}
+}
+
+// MergeSeq inserts every key-value pair from the given sequence, which
+// need not be in key order. Where a key is already in the tree, its value
+// becomes onConflict(existingValue, incomingValue), or if onConflict is
+// nil, incomingValue. For example:
+//
+//	tree.MergeSeq(counts, func(a, b int) int { return a + b })
+//
+// Each pair takes a single descent of the tree.
+// See also [InsertMany] and [Union]
+func (me *SortedMap[K, V]) MergeSeq(seq iter.Seq2[K, V],
+	onConflict func(existing, incoming V) V,
+) {
+	if onConflict == nil {
+		onConflict = replaceValue
+	}
+	for key, value := range seq {
+		me.put(key, value, onConflict)
+	}
+}
+
+// InsertPairs inserts each keys[i]-values[i] pair, replacing the values
+// of any keys already in the tree. If the slices’ lengths differ it
+// returns an error wrapping [ErrLengthMismatch] and inserts nothing. For
+// example:
+//
+//	err := tree.InsertPairs(names, ages)
+//
+// See also [InsertMany]
+func (me *SortedMap[K, V]) InsertPairs(keys []K, values []V) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("%w: got %d keys and %d values",
+			ErrLengthMismatch, len(keys), len(values))
+	}
+	for i, key := range keys {
+		me.Insert(key, values[i])
+	}
+	return nil
+}
+
+// CopyFrom inserts every key-value pair from the given sequence into the
+// tree, replacing the values of any keys already present; it is
+// equivalent to [InsertMany]. For example, to copy one tree into another,
+// or to load a generator’s output:
+//
+//	tree.CopyFrom(other.All())
+//	tree.CopyFrom(generate(n))
+func (me *SortedMap[K, V]) CopyFrom(seq iter.Seq2[K, V]) {
+	me.InsertMany(seq)
+}
+
+// NewFromSorted returns a new tree holding the given items. If the items
+// are in strictly ascending key order (i.e., sorted with no duplicate
+// keys), as when loading persisted data, the balanced tree is built
+// directly in O(n) rather than by O(n log n) insertions. For example:
+//
+//	tree := sortedmap.NewFromSorted(items)
+//
+// Otherwise the items are inserted one at a time, with later items
+// replacing the values of earlier ones that have equal keys.
+// See also [BulkLoadSorted]
+func NewFromSorted[K Ordered, V any](
+	items []Item[K, V],
+) *SortedMap[K, V] {
+	tree := &SortedMap[K, V]{}
+	tree.BulkLoadSorted(items)
+	return tree
+}
+
+// BulkLoadSorted inserts all the given items. If the tree is empty and
+// the items are in strictly ascending key order (i.e., sorted with no
+// duplicate keys), the tree is built directly in O(n). Otherwise the
+// items are inserted one at a time in O(n log n), with later items
+// replacing the values of earlier ones that have equal keys.
+// See also [InsertMany]
+func (me *SortedMap[K, V]) BulkLoadSorted(items []Item[K, V]) {
+	if me.root != nil || !isStrictlyAscending(items) {
+		for _, item := range items {
+			me.Insert(item.Key, item.Value)
+		}
+		return
+	}
+	me.root = me.build(items)
+	me.size = len(items)
+	for _, item := range items {
+		me.hooks.inserted(item.Key, item.Value)
+	}
+}
+
+// Rebuild rebuilds the tree from scratch into an optimally balanced
+// shape in O(n). Although a red-black tree always stays near-balanced,
+// after many interleaved insertions and deletions a rebuild can reduce
+// its height (e.g., see [Height]) and improve memory locality, which may
+// make subsequent lookups faster. If [Reserve] has been called, the
+// rebuilt tree reuses the tree’s existing nodes.
+func (me *SortedMap[K, V]) Rebuild() {
+	items := make([]Item[K, V], 0, me.size)
+	for key, value := range me.All() {
+		items = append(items, Item[K, V]{key, value})
+	}
+	me.clear()
+	me.root = me.build(items)
+	me.size = len(items)
+}
+
+// Shrink rebuilds the tree like [Rebuild] but with all its nodes newly
+// allocated in a single contiguous block, and releases any reserved or
+// recycled nodes (although if [Reserve] has been called, [Clear] still
+// keeps capacity afterwards). Use this after deleting most of a large
+// tree’s items to free memory and improve the locality of subsequent
+// lookups. For example:
+//
+//	if tree.Len() < peak/4 {
+//		tree.Shrink()
+//	}
+//
+// This is O(n).
+func (me *SortedMap[K, V]) Shrink() {
+	items := make([]Item[K, V], 0, me.size)
+	for key, value := range me.All() {
+		items = append(items, Item[K, V]{key, value})
+	}
+	me.free = nil
+	me.pool = make([]node[K, V], len(items))
+	me.root = me.build(items)
+	me.size = len(items)
+	me.shared = false
+}
+
+// fromSorted returns a new tree holding the given items which must be in
+// strictly ascending key order.
+func fromSorted[K Ordered, V any](items []Item[K, V]) *SortedMap[K, V] {
+	tree := &SortedMap[K, V]{size: len(items)}
+	tree.root = tree.build(items)
+	return tree
+}
+
+func isStrictlyAscending[K Ordered, V any](items []Item[K, V]) bool {
+	for i := 1; i < len(items); i++ {
+		if !(items[i-1].Key < items[i].Key) {
+			return false
+		}
+	}
+	return true
+}
+
+// build returns a left-leaning red-black tree holding the given items
+// (which must be strictly ascending) by treating it as a 2-3 tree with
+// every leaf at the same depth, i.e., with the greatest black height,
+// blackHeight, for which 2^blackHeight - 1 <= len(items). A subtree of
+// black height h can hold from 2^h - 1 to 3^h - 1 items.
+func (me *SortedMap[K, V]) build(items []Item[K, V]) *node[K, V] {
+	blackHeight := bits.Len(uint(len(items)+1)) - 1
+	maxSize := make([]int, blackHeight+1) // maxSize[h] == 3^h - 1
+	for h, power := 1, 1; h <= blackHeight; h++ {
+		if power <= math.MaxInt/3 {
+			power *= 3
+		}
+		maxSize[h] = power - 1
+	}
+	return me.buildNode(items, blackHeight, maxSize)
+}
+
+func (me *SortedMap[K, V]) buildNode(items []Item[K, V],
+	blackHeight int, maxSize []int,
+) *node[K, V] {
+	if blackHeight == 0 {
+		return nil
+	}
+	size := len(items)
+	if (size-1)-(size-1)/2 <= maxSize[blackHeight-1] { // 2-node
+		mid := size / 2
+		root := me.newNode(items[mid].Key, items[mid].Value)
+		root.red = false
+		root.size = size
+		root.left = me.buildNode(items[:mid], blackHeight-1, maxSize)
+		root.right = me.buildNode(items[mid+1:], blackHeight-1, maxSize)
+		return root
+	}
+	// 3-node: a black node with a red left child
+	i := (size - 2) / 3
+	j := i + 1 + (size-2-i)/2
+	red := me.newNode(items[i].Key, items[i].Value)
+	red.size = j
+	red.left = me.buildNode(items[:i], blackHeight-1, maxSize)
+	red.right = me.buildNode(items[i+1:j], blackHeight-1, maxSize)
+	root := me.newNode(items[j].Key, items[j].Value)
+	root.red = false
+	root.size = size
+	root.left = red
+	root.right = me.buildNode(items[j+1:], blackHeight-1, maxSize)
+	return root
+}
+
 // Len returns the number of items in the tree.
 func (me *SortedMap[K, V]) Len() int { return me.size }
 
@@ -123,19 +574,114 @@ func (me *SortedMap[K, V]) Len() int { return me.size }
 // See also [Keys] and [Values]
 func (me *SortedMap[K, V]) All() iter.Seq2[K, V] {
 	return func(yield func(K, V) bool) {
-		all(me.root, yield)
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(root.key, root.value) {
+				return
+			}
+		}
 	}
 }
 
-func all[K Comparable, V any](root *node[K, V],
-	yield func(K, V) bool,
-) bool {
-	if root != nil {
-		return all(root.left, yield) &&
-			yield(root.key, root.value) &&
-			all(root.right, yield)
+// The tree’s height is at most 2·log₂(n+1) so this is enough for any tree
+// that fits in memory.
+const maxHeight = 128
+
+// inOrder is an explicit stack used to iterate over a tree in key order
+// without recursion and using O(height) memory.
+type inOrder[K Ordered, V any] struct {
+	nodes [maxHeight]*node[K, V]
+	depth int
+}
+
+// pushLeft pushes the root and its chain of left descendants.
+func (me *inOrder[K, V]) pushLeft(root *node[K, V]) {
+	for ; root != nil; root = root.left {
+		me.nodes[me.depth] = root
+		me.depth++
 	}
-	return true
+}
+
+// pushFrom pushes the nodes on the path to start whose keys are >= start
+// so that the first node returned by next() has the first key >= start.
+func (me *inOrder[K, V]) pushFrom(root *node[K, V], start K) {
+	for root != nil {
+		if root.key < start {
+			root = root.right
+		} else {
+			me.nodes[me.depth] = root
+			me.depth++
+			root = root.left
+		}
+	}
+}
+
+// pushAfter pushes the nodes on the path to after whose keys are > after
+// so that the first node returned by next() has the first key > after.
+func (me *inOrder[K, V]) pushAfter(root *node[K, V], after K) {
+	for root != nil {
+		if root.key > after {
+			me.nodes[me.depth] = root
+			me.depth++
+			root = root.left
+		} else {
+			root = root.right
+		}
+	}
+}
+
+// next returns the next node in key order or nil when there are no more.
+func (me *inOrder[K, V]) next() *node[K, V] {
+	if me.depth == 0 {
+		return nil
+	}
+	me.depth--
+	root := me.nodes[me.depth]
+	me.pushLeft(root.right)
+	return root
+}
+
+// reverseOrder is an explicit stack used to iterate over a tree in
+// descending key order; it mirrors [inOrder].
+type reverseOrder[K Ordered, V any] struct {
+	nodes [maxHeight]*node[K, V]
+	depth int
+}
+
+// pushRight pushes the root and its chain of right descendants.
+func (me *reverseOrder[K, V]) pushRight(root *node[K, V]) {
+	for ; root != nil; root = root.right {
+		me.nodes[me.depth] = root
+		me.depth++
+	}
+}
+
+// pushBefore pushes the nodes on the path to before whose keys are
+// < before so that the first node returned by prev() has the last key
+// < before.
+func (me *reverseOrder[K, V]) pushBefore(root *node[K, V], before K) {
+	for root != nil {
+		if root.key < before {
+			me.nodes[me.depth] = root
+			me.depth++
+			root = root.right
+		} else {
+			root = root.left
+		}
+	}
+}
+
+// prev returns the previous node in key order or nil when there are no
+// more.
+func (me *reverseOrder[K, V]) prev() *node[K, V] {
+	if me.depth == 0 {
+		return nil
+	}
+	me.depth--
+	root := me.nodes[me.depth]
+	me.pushRight(root.left)
+	return root
 }
 
 // Equal returns true if this SortedSet has the same elements as the other
@@ -160,19 +706,14 @@ func (me *SortedMap[K, V]) Equal(other SortedMap[K, V]) bool {
 // See also [All] and [Values]
 func (me *SortedMap[K, V]) Keys() iter.Seq[K] {
 	return func(yield func(K) bool) {
-		keys(me.root, yield)
-	}
-}
-
-func keys[K Comparable, V any](root *node[K, V],
-	yield func(K) bool,
-) bool {
-	if root != nil {
-		return keys(root.left, yield) &&
-			yield(root.key) &&
-			keys(root.right, yield)
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(root.key) {
+				return
+			}
+		}
 	}
-	return true
 }
 
 // Values is a range function for use as an iterable in a
@@ -183,174 +724,2302 @@ func keys[K Comparable, V any](root *node[K, V],
 // See also [All] and [Keys]
 func (me *SortedMap[K, V]) Values() iter.Seq[V] {
 	return func(yield func(V) bool) {
-		values(me.root, yield)
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(root.value) {
+				return
+			}
+		}
 	}
 }
 
-func values[K Comparable, V any](root *node[K, V],
-	yield func(V) bool,
-) bool {
-	if root != nil {
-		return values(root.left, yield) &&
-			yield(root.value) &&
-			values(root.right, yield)
+// Snapshot is a range function for use as an iterable in a for … range
+// loop that returns the tree’s keys and values in key order as they were
+// when Snapshot was called. Unlike with [All] and the other live
+// iterators, the tree may be freely changed during the loop, e.g.,
+//
+//	for key, value := range tree.Snapshot() {
+//		if value < 0 {
+//			tree.Delete(key)
+//		}
+//	}
+//
+// The price is that Snapshot copies every key-value item, taking O(n)
+// time and memory up front, whereas the live iterators use only
+// O(log n) memory.
+func (me *SortedMap[K, V]) Snapshot() iter.Seq2[K, V] {
+	items := make([]Item[K, V], 0, me.size)
+	for key, value := range me.All() {
+		items = append(items, Item[K, V]{key, value})
+	}
+	return func(yield func(K, V) bool) {
+		for _, item := range items {
+			if !yield(item.Key, item.Value) {
+				return
+			}
+		}
 	}
-	return true
-}
-
-// Contains returns true if the key is in the tree and false otherwise.
-func (me *SortedMap[K, V]) Contains(key K) bool {
-	_, found := me.Find(key)
-	return found
 }
 
-// Find returns the value and true if the key is in the tree
-// or V’s zero value and false otherwise. For example:
+// Enumerate is a range function for use as an iterable in a
+// for … range loop that returns each of the tree’s key-value items in key
+// order, together with its zero-based index:
 //
-//	value, ok := tree.Find(key)
-func (me *SortedMap[K, V]) Find(key K) (V, bool) {
-	var zero V
-	root := me.root
-	for root != nil {
-		if key < root.key {
-			root = root.left
-		} else if key > root.key {
-			root = root.right
-		} else {
-			return root.value, true
+//	for i, item := range tree.Enumerate()
+//
+// See also [All]
+func (me *SortedMap[K, V]) Enumerate() iter.Seq2[int, Item[K, V]] {
+	return func(yield func(int, Item[K, V]) bool) {
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		i := 0
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(i, Item[K, V]{root.key, root.value}) {
+				return
+			}
+			i++
 		}
 	}
-	return zero, false
 }
 
-// Delete deletes the key-value item with the given key from the
-// tree and returns true, or does nothing and returns false if
-// there is no key-value with the given key. For example:
+// Stride is a range function for use as an iterable in a for … range
+// loop that returns every n-th of the tree’s key-value items in key order,
+// i.e., those at zero-based positions 0, n, 2n, and so on (if n < 1 it is
+// treated as 1). For example, to plot at most 100 points:
 //
-//	ok := tree.Delete(key).
+//	for key, value := range tree.Stride(max(1, tree.Len()/100))
 //
-// See also [Clear]
-func (me *SortedMap[K, V]) Delete(key K) bool {
-	deleted := false
-	if me.root != nil {
-		if me.root, deleted = delete_(me.root,
-			key); me.root != nil {
-			me.root.red = false
+// This walks the tree in order so is O(n), although only every n-th item
+// is yielded. (Calling [Select] for each position would be O(k log n) for
+// k items, but is slower unless n is large.)
+func (me *SortedMap[K, V]) Stride(n int) iter.Seq2[K, V] {
+	n = max(1, n)
+	return func(yield func(K, V) bool) {
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		i := 0
+		for root := stack.next(); root != nil; root = stack.next() {
+			if i%n == 0 && !yield(root.key, root.value) {
+				return
+			}
+			i++
 		}
 	}
-	if deleted {
-		me.size--
+}
+
+// KeysSlice returns a new slice of all the tree’s keys in ascending order.
+// See also [Keys] and [ValuesSlice]
+func (me *SortedMap[K, V]) KeysSlice() []K {
+	keys := make([]K, 0, me.size)
+	for key := range me.Keys() {
+		keys = append(keys, key)
 	}
-	return deleted
+	return keys
 }
 
-func delete_[K Comparable, V any](root *node[K, V], key K) (
-	*node[K, V], bool,
-) {
-	deleted := false
-	if key < root.key {
-		if root.left != nil {
-			if !isRed(root.left) && !isRed(root.left.left) {
-				root = moveRedLeft(root)
-			}
-			root.left, deleted = delete_(root.left, key)
-		}
-	} else {
-		if isRed(root.left) {
-			root = rotateRight(root)
-		}
-		if key == root.key && root.right == nil {
-			// free(root)
-			return nil, true
-		}
-		if root.right != nil {
-			root, deleted = deleteRight(root, key)
-		}
+// ValuesSlice returns a new slice of all the tree’s values in ascending
+// key order.
+// See also [Values] and [KeysSlice]
+func (me *SortedMap[K, V]) ValuesSlice() []V {
+	values := make([]V, 0, me.size)
+	for value := range me.Values() {
+		values = append(values, value)
 	}
-	return fixUp(root), deleted
+	return values
 }
 
-func moveRedLeft[K Comparable, V any](
-	root *node[K, V],
-) *node[K, V] {
-	colorFlip(root)
-	if root.right != nil && isRed(root.right.left) {
+// MinN returns a new slice of up to n of the tree’s key-value items with
+// the smallest keys, in ascending key order. If the tree has fewer than n
+// items, all of them are returned. Only O(log n + n) nodes are visited.
+// See also [MaxN]
+func (me *SortedMap[K, V]) MinN(n int) []Item[K, V] {
+	n = max(0, min(n, me.size))
+	items := make([]Item[K, V], 0, n)
+	var stack inOrder[K, V]
+	stack.pushLeft(me.root)
+	for len(items) < n {
+		root := stack.next()
+		items = append(items, Item[K, V]{root.key, root.value})
+	}
+	return items
+}
+
+// MaxN returns a new slice of up to n of the tree’s key-value items with
+// the largest keys, in descending key order. If the tree has fewer than n
+// items, all of them are returned. Only O(log n + n) nodes are visited.
+// See also [MinN]
+func (me *SortedMap[K, V]) MaxN(n int) []Item[K, V] {
+	n = max(0, min(n, me.size))
+	items := make([]Item[K, V], 0, n)
+	var stack reverseOrder[K, V]
+	stack.pushRight(me.root)
+	for len(items) < n {
+		root := stack.prev()
+		items = append(items, Item[K, V]{root.key, root.value})
+	}
+	return items
+}
+
+// AllFrom is a range function for use as an iterable in a
+// for … range loop that returns the tree’s keys and values starting from
+// the first key that is >= start, e.g.,
+//
+//	for key, value := range tree.AllFrom(lastSeen)
+//
+// Subtrees whose keys are all less than start are never visited.
+// See also [All]
+func (me *SortedMap[K, V]) AllFrom(start K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		var stack inOrder[K, V]
+		stack.pushFrom(me.root, start)
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(root.key, root.value) {
+				return
+			}
+		}
+	}
+}
+
+// Range is a range function for use as an iterable in a
+// for … range loop that returns the tree’s keys and values for keys in
+// the half-open range [lo, hi), e.g.,
+//
+//	for key, value := range tree.Range("a", "n")
+//
+// Subtrees wholly outside the range are never visited.
+// See also [RangeKeys], [RangeValues], and [AllFrom]
+func (me *SortedMap[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		var stack inOrder[K, V]
+		stack.pushFrom(me.root, lo)
+		root := stack.next()
+		for ; root != nil && root.key < hi; root = stack.next() {
+			if !yield(root.key, root.value) {
+				return
+			}
+		}
+	}
+}
+
+// RangeBackward is a range function for use as an iterable in a
+// for … range loop that returns the tree’s keys and values for keys in
+// the half-open range [lo, hi) in descending key order, e.g.,
+//
+//	for key, value := range tree.RangeBackward(start, end)
+//
+// Subtrees wholly outside the range are never visited.
+// See also [Range]
+func (me *SortedMap[K, V]) RangeBackward(lo, hi K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		var stack reverseOrder[K, V]
+		stack.pushBefore(me.root, hi)
+		root := stack.prev()
+		for ; root != nil && root.key >= lo; root = stack.prev() {
+			if !yield(root.key, root.value) {
+				return
+			}
+		}
+	}
+}
+
+// RangeKeys is a range function for use as an iterable in a
+// for … range loop that returns the tree’s keys in the half-open range
+// [lo, hi):
+//
+//	for key := range tree.RangeKeys(lo, hi)
+//
+// See also [Range] and [RangeValues]
+func (me *SortedMap[K, V]) RangeKeys(lo, hi K) iter.Seq[K] {
+	return func(yield func(K) bool) {
+		var stack inOrder[K, V]
+		stack.pushFrom(me.root, lo)
+		root := stack.next()
+		for ; root != nil && root.key < hi; root = stack.next() {
+			if !yield(root.key) {
+				return
+			}
+		}
+	}
+}
+
+// RangeValues is a range function for use as an iterable in a
+// for … range loop that returns the tree’s values for keys in the
+// half-open range [lo, hi):
+//
+//	for value := range tree.RangeValues(lo, hi)
+//
+// See also [Range] and [RangeKeys]
+func (me *SortedMap[K, V]) RangeValues(lo, hi K) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		var stack inOrder[K, V]
+		stack.pushFrom(me.root, lo)
+		root := stack.next()
+		for ; root != nil && root.key < hi; root = stack.next() {
+			if !yield(root.value) {
+				return
+			}
+		}
+	}
+}
+
+// ForEachRange calls fn on each of the tree’s key-value items whose keys
+// are in the half-open range [lo, hi), in key order, stopping as soon as
+// fn returns false. It is the callback equivalent of [Range] and visits
+// the same nodes. For example:
+//
+//	tree.ForEachRange(lo, hi, func(key string, value int) bool {
+//		fmt.Println(key, value)
+//		return true
+//	})
+func (me *SortedMap[K, V]) ForEachRange(lo, hi K, fn func(K, V) bool) {
+	for key, value := range me.Range(lo, hi) {
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// FirstKey returns the tree’s smallest key and true, or K’s zero value
+// and false if the tree is empty.
+// See also [LastKey] and [MinN]
+func (me *SortedMap[K, V]) FirstKey() (K, bool) {
+	if me.root == nil {
+		var zero K
+		return zero, false
+	}
+	return first(me.root).key, true
+}
+
+// LastKey returns the tree’s largest key and true, or K’s zero value and
+// false if the tree is empty. For example:
+//
+//	if last, ok := tree.LastKey(); !ok || stamp > last {
+//		tree.Insert(stamp, event) // newer than everything stored
+//	}
+//
+// See also [FirstKey] and [MaxN]
+func (me *SortedMap[K, V]) LastKey() (K, bool) {
+	if me.root == nil {
+		var zero K
+		return zero, false
+	}
+	return last(me.root).key, true
+}
+
+// MinValue returns the value of the item with the smallest key and true,
+// or V’s zero value and false if the tree is empty.
+// See also [MaxValue] and [FirstKey]
+func (me *SortedMap[K, V]) MinValue() (V, bool) {
+	if me.root == nil {
+		var zero V
+		return zero, false
+	}
+	return first(me.root).value, true
+}
+
+// MaxValue returns the value of the item with the largest key and true,
+// or V’s zero value and false if the tree is empty. For example, if the
+// keys are timestamps:
+//
+//	latest, ok := readings.MaxValue()
+//
+// See also [MinValue] and [LastKey]
+func (me *SortedMap[K, V]) MaxValue() (V, bool) {
+	if me.root == nil {
+		var zero V
+		return zero, false
+	}
+	return last(me.root).value, true
+}
+
+// Contains returns true if the key is in the tree and false otherwise.
+func (me *SortedMap[K, V]) Contains(key K) bool {
+	_, found := me.Find(key)
+	return found
+}
+
+// floorNode returns the node with the largest key <= key or nil.
+func (me *SortedMap[K, V]) floorNode(key K) *node[K, V] {
+	var candidate *node[K, V]
+	for root := me.root; root != nil; {
+		if key < root.key {
+			root = root.left
+		} else if key > root.key {
+			candidate = root
+			root = root.right
+		} else {
+			return root
+		}
+	}
+	return candidate
+}
+
+// ceilingNode returns the node with the smallest key >= key or nil.
+func (me *SortedMap[K, V]) ceilingNode(key K) *node[K, V] {
+	var candidate *node[K, V]
+	for root := me.root; root != nil; {
+		if key < root.key {
+			candidate = root
+			root = root.left
+		} else if key > root.key {
+			root = root.right
+		} else {
+			return root
+		}
+	}
+	return candidate
+}
+
+// ContainsAll returns true if every one of the given keys is in the tree
+// (or if no keys are given); otherwise it returns false as soon as it
+// finds a missing key.
+// See also [ContainsAny]
+func (me *SortedMap[K, V]) ContainsAll(keys ...K) bool {
+	for _, key := range keys {
+		if !me.Contains(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny returns true as soon as it finds one of the given keys in
+// the tree; otherwise (including if no keys are given) it returns false.
+// See also [ContainsAll]
+func (me *SortedMap[K, V]) ContainsAny(keys ...K) bool {
+	for _, key := range keys {
+		if me.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsRange returns true if at least one key is in the half-open
+// range [lo, hi); otherwise (including if lo >= hi) returns false. For
+// example, to check that a new booking doesn’t overlap any start time:
+//
+//	clash := bookings.ContainsRange(start, end)
+//
+// This is O(log n) since it only looks for the first key >= lo.
+// See also [Count]
+func (me *SortedMap[K, V]) ContainsRange(lo, hi K) bool {
+	if !(lo < hi) {
+		return false
+	}
+	ceiling := me.ceilingNode(lo)
+	return ceiling != nil && ceiling.key < hi
+}
+
+// Find returns the value and true if the key is in the tree
+// or V’s zero value and false otherwise. For example:
+//
+//	value, ok := tree.Find(key)
+func (me *SortedMap[K, V]) Find(key K) (V, bool) {
+	if root := me.findNode(key); root != nil {
+		return root.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// FindRef returns a pointer to the value stored with the given key and
+// true, or nil and false if the key isn’t in the tree. The value can be
+// read or changed in place through the pointer, which avoids copying
+// large values in and out. For example:
+//
+//	if account, ok := tree.FindRef(id); ok {
+//		account.Balance += amount
+//	}
+//
+// The pointer is only valid until the tree is next changed other than by
+// inserting or replacing values. In particular, deleting any key (not
+// just this one) may move another item’s value into this key’s node, and
+// methods like [Clear] and [Rebuild] discard or recycle nodes, so after
+// such a change the pointer may refer to some other value or to none.
+// Taking a version of the tree with [WithInsert] or [WithDelete] also
+// invalidates the pointer, since its node is then shared with the new
+// version, so writing through it would change both; call FindRef again
+// afterwards to get a pointer that only changes this tree.
+func (me *SortedMap[K, V]) FindRef(key K) (*V, bool) {
+	if root := me.findOwnedNode(key); root != nil {
+		return &root.value, true
+	}
+	return nil, false
+}
+
+// ReplaceValue replaces the value of an existing key and returns true, or
+// does nothing and returns false if the key isn’t in the tree. Unlike
+// [Insert], it never adds a new key. For example:
+//
+//	ok := tree.ReplaceValue(key, value)
+func (me *SortedMap[K, V]) ReplaceValue(key K, value V) bool {
+	if root := me.findOwnedNode(key); root != nil {
+		oldValue := root.value
+		root.value = value
+		me.hooks.replaced(key, oldValue, value)
+		return true
+	}
+	return false
+}
+
+// CompareAndSwapValue replaces the value of an existing key with
+// newValue and returns true if eq(currentValue, oldValue) returns true;
+// otherwise it does nothing and returns false (including if the key isn’t
+// in the tree). The key is found in a single descent. For example:
+//
+//	ok := tree.CompareAndSwapValue(key, seen, updated, eq)
+func (me *SortedMap[K, V]) CompareAndSwapValue(key K, oldValue, newValue V,
+	eq func(a, b V) bool,
+) bool {
+	if root := me.findNode(key); root != nil && eq(root.value, oldValue) {
+		root = me.findOwnedNode(key)
+		oldValue, root.value = root.value, newValue
+		me.hooks.replaced(key, oldValue, newValue)
+		return true
+	}
+	return false
+}
+
+// Swap exchanges the values of the a and b keys and returns true, or does
+// nothing and returns false if either key isn’t in the tree. The tree’s
+// structure is unchanged. For example:
+//
+//	ok := tree.Swap(first, second)
+func (me *SortedMap[K, V]) Swap(a, b K) bool {
+	if me.findNode(a) == nil || me.findNode(b) == nil {
+		return false
+	}
+	x, y := me.findOwnedNode(a), me.findOwnedNode(b)
+	x.value, y.value = y.value, x.value
+	if a != b {
+		me.hooks.replaced(a, y.value, x.value)
+		me.hooks.replaced(b, x.value, y.value)
+	}
+	return true
+}
+
+// GetOr returns the value if the key is in the tree or the fallback
+// otherwise. For example:
+//
+//	total += tree.GetOr(key, 0)
+//
+// See also [Find]
+func (me *SortedMap[K, V]) GetOr(key K, fallback V) V {
+	if root := me.findNode(key); root != nil {
+		return root.value
+	}
+	return fallback
+}
+
+func (me *SortedMap[K, V]) findNode(key K) *node[K, V] {
+	root := me.root
+	for root != nil {
+		if key < root.key {
+			root = root.left
+		} else if key > root.key {
+			root = root.right
+		} else {
+			return root
+		}
+	}
+	return nil
+}
+
+// Delete deletes the key-value item with the given key from the
+// tree and returns true, or does nothing and returns false if
+// there is no key-value with the given key. For example:
+//
+//	ok := tree.Delete(key).
+//
+// See also [Clear]
+func (me *SortedMap[K, V]) Delete(key K) bool {
+	var value V
+	if me.hooks.watchesDeletes() || me.shared {
+		root := me.findNode(key)
+		if root == nil {
+			return false // don't copy shared nodes if nothing would change
+		}
+		value = root.value
+	}
+	me.own()
+	deleted := false
+	if me.root != nil {
+		if !isRed(me.root.left) && !isRed(me.root.right) {
+			me.root.red = true
+		}
+		if me.root, deleted = delete_(me.root,
+			key); me.root != nil {
+			me.root.red = false
+		}
+	}
+	if deleted {
+		me.size--
+		me.hooks.deleted(key, value)
+	}
+	return deleted
+}
+
+// Rename moves the value of the from key to the to key and returns true,
+// or does nothing and returns false if the from key isn’t in the tree. If
+// the to key is already in the tree, its value is overwritten. The size
+// is reduced by one if the to key was already present, and is otherwise
+// unchanged. For example:
+//
+//	ok := tree.Rename(oldName, newName)
+func (me *SortedMap[K, V]) Rename(from, to K) bool {
+	root := me.findNode(from)
+	if root == nil {
+		return false
+	}
+	if from != to {
+		value := root.value
+		me.Delete(from)
+		me.Insert(to, value)
+	}
+	return true
+}
+
+// DeleteMany deletes the key-value items with the given keys and returns
+// how many were actually deleted (keys not in the tree are ignored). The
+// tree remains balanced throughout. For example:
+//
+//	count := tree.DeleteMany(stale...)
+//
+// See also [DeleteSeq] and [Delete]
+func (me *SortedMap[K, V]) DeleteMany(keys ...K) int {
+	count := 0
+	for _, key := range keys {
+		if me.Delete(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteSeq deletes the key-value items with the keys from the given
+// sequence and returns how many were actually deleted. The sequence must
+// not come from this tree (e.g., from its Keys()) since deleting during
+// iteration is unsafe; use [DeleteFunc] for that. For example:
+//
+//	count := tree.DeleteSeq(purge.Keys())
+//
+// See also [DeleteMany]
+func (me *SortedMap[K, V]) DeleteSeq(keys iter.Seq[K]) int {
+	count := 0
+	for key := range keys {
+		if me.Delete(key) {
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteMin deletes the key-value item with the smallest key and returns
+// true, or does nothing and returns false if the tree is empty.
+// See also [DeleteMax] and [Delete]
+func (me *SortedMap[K, V]) DeleteMin() bool {
+	if me.root == nil {
+		return false
+	}
+	me.own()
+	removed := first(me.root)
+	key, value := removed.key, removed.value
+	if !isRed(me.root.left) && !isRed(me.root.right) {
+		me.root.red = true
+	}
+	if me.root = deleteMinimum(me.root); me.root != nil {
+		me.root.red = false
+	}
+	me.size--
+	me.hooks.deleted(key, value)
+	return true
+}
+
+// DeleteMax deletes the key-value item with the largest key and returns
+// true, or does nothing and returns false if the tree is empty.
+// See also [DeleteMin] and [Delete]
+func (me *SortedMap[K, V]) DeleteMax() bool {
+	if me.root == nil {
+		return false
+	}
+	me.own()
+	removed := last(me.root)
+	key, value := removed.key, removed.value
+	if !isRed(me.root.left) && !isRed(me.root.right) {
+		me.root.red = true
+	}
+	if me.root = deleteMaximum(me.root); me.root != nil {
+		me.root.red = false
+	}
+	me.size--
+	me.hooks.deleted(key, value)
+	return true
+}
+
+// DeleteFunc deletes every key-value item for which pred returns true and
+// returns how many were deleted. For example:
+//
+//	count := tree.DeleteFunc(func(_ string, value int) bool {
+//		return value < 0
+//	})
+//
+// Since deleting during iteration is unsafe, the matching keys are
+// collected first and then deleted.
+// See also [Delete]
+func (me *SortedMap[K, V]) DeleteFunc(pred func(K, V) bool) int {
+	var keys []K
+	for key, value := range me.All() {
+		if pred(key, value) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		me.Delete(key)
+	}
+	return len(keys)
+}
+
+// Trim deletes every key-value item whose key is outside the half-open
+// range [lo, hi), and returns how many were deleted. (If lo >= hi, every
+// item is deleted.) For example, to keep only the last week’s entries:
+//
+//	count := tree.Trim(weekAgo, tomorrow)
+//
+// This is O(k log n) for k deletions.
+// See also [DeleteFunc]
+func (me *SortedMap[K, V]) Trim(lo, hi K) int {
+	size := me.size
+	for me.root != nil && first(me.root).key < lo {
+		me.DeleteMin()
+	}
+	for me.root != nil && !(last(me.root).key < hi) {
+		me.DeleteMax()
+	}
+	return size - me.size
+}
+
+// KeepSmallest deletes every key-value item except those with the n
+// smallest keys, and returns how many were deleted. For example, to
+// retain only the oldest 100 entries:
+//
+//	count := tree.KeepSmallest(100)
+//
+// This is O(k log n) for k deletions.
+// See also [KeepLargest], [MinN], and [Trim]
+func (me *SortedMap[K, V]) KeepSmallest(n int) int {
+	size := me.size
+	for me.size > max(0, n) {
+		me.DeleteMax()
+	}
+	return size - me.size
+}
+
+// KeepLargest deletes every key-value item except those with the n
+// largest keys, and returns how many were deleted. For example, to
+// retain only the latest 100 entries:
+//
+//	count := tree.KeepLargest(100)
+//
+// This is O(k log n) for k deletions.
+// See also [KeepSmallest], [MaxN], and [Trim]
+func (me *SortedMap[K, V]) KeepLargest(n int) int {
+	size := me.size
+	for me.size > max(0, n) {
+		me.DeleteMin()
+	}
+	return size - me.size
+}
+
+// TruncateTo caps the tree’s size at n by deleting every key-value item
+// except those with the n smallest keys, and returns how many were
+// deleted. This is useful for bounded sorted buffers. For example:
+//
+//	for reading := range readings {
+//		buffer.Insert(reading.Time, reading)
+//		buffer.TruncateTo(limit)
+//	}
+//
+// TruncateTo does the same as [KeepSmallest], but when more items are to
+// be deleted than kept, it rebuilds the tree from the kept items rather
+// than deleting the others one by one, so it is O(min(k log n, n)) for k
+// deletions. (If there is an OnDelete hook (see [Hooks]), it always
+// deletes the items one by one.) If [Reserve] has been called, the
+// rebuilt tree reuses the tree’s existing nodes.
+func (me *SortedMap[K, V]) TruncateTo(n int) int {
+	n = max(0, n)
+	size := me.size
+	if size-n <= n || me.hooks.watchesDeletes() {
+		return me.KeepSmallest(n)
+	}
+	items := me.MinN(n)
+	me.clear()
+	me.root = me.build(items)
+	me.size = len(items)
+	return size - me.size
+}
+
+// delete_ deletes the node with the given key from the root’s subtree;
+// see insert about keeping keyedDelete in step.
+func delete_[K Ordered, V any](root *node[K, V], key K) (
+	*node[K, V], bool,
+) {
+	deleted := false
+	if key < root.key {
+		if root.left != nil {
+			if !isRed(root.left) && !isRed(root.left.left) {
+				root = moveRedLeft(root)
+			}
+			root.left, deleted = delete_(root.left, key)
+		}
+	} else {
+		if isRed(root.left) {
+			root = rotateRight(root)
+		}
+		if key == root.key && root.right == nil {
+			// free(root)
+			return nil, true
+		}
+		if root.right != nil {
+			root, deleted = deleteRight(root, key)
+		}
+	}
+	return fixUp(root), deleted
+}
+
+func moveRedLeft[K Ordered, V any](
+	root *node[K, V],
+) *node[K, V] {
+	colorFlip(root)
+	if root.right != nil && isRed(root.right.left) {
 		root.right = rotateRight(root.right)
 		root = rotateLeft(root)
 		colorFlip(root)
 	}
-	return root
+	return root
+}
+
+func deleteRight[K Ordered, V any](root *node[K, V], key K) (
+	*node[K, V], bool,
+) {
+	deleted := false
+	if !isRed(root.right) && !isRed(root.right.left) {
+		root = moveRedRight(root)
+	}
+	if key == root.key {
+		smallest := first(root.right)
+		root.key = smallest.key
+		root.value = smallest.value
+		root.right = deleteMinimum(root.right)
+		deleted = true
+	} else {
+		root.right, deleted = delete_(root.right, key)
+	}
+	return root, deleted
+}
+
+func moveRedRight[K Ordered, V any](
+	root *node[K, V],
+) *node[K, V] {
+	colorFlip(root)
+	if root.left != nil && isRed(root.left.left) {
+		root = rotateRight(root)
+		colorFlip(root)
+	}
+	return root
+}
+
+// We do not provide an exported First() method because this
+// is an implementation detail.
+func first[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	for root.left != nil {
+		root = root.left
+	}
+	return root
+}
+
+func last[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	for root.right != nil {
+		root = root.right
+	}
+	return root
+}
+
+func deleteMinimum[K Ordered, V any](
+	root *node[K, V],
+) *node[K, V] {
+	if root.left == nil {
+		// free(root)
+		return nil
+	}
+	if !isRed(root.left) && !isRed(root.left.left) {
+		root = moveRedLeft(root)
+	}
+	root.left = deleteMinimum(root.left)
+	return fixUp(root)
+}
+
+func deleteMaximum[K Ordered, V any](
+	root *node[K, V],
+) *node[K, V] {
+	if isRed(root.left) {
+		root = rotateRight(root)
+	}
+	if root.right == nil {
+		// free(root)
+		return nil
+	}
+	if !isRed(root.right) && !isRed(root.right.left) {
+		root = moveRedRight(root)
+	}
+	root.right = deleteMaximum(root.right)
+	return fixUp(root)
+}
+
+func fixUp[K Ordered, V any](root *node[K, V]) *node[K, V] {
+	resize(root)
+	if isRed(root.right) {
+		root = rotateLeft(root)
+	}
+	if isRed(root.left) && isRed(root.left.left) {
+		root = rotateRight(root)
+	}
+	if isRed(root.left) && isRed(root.right) {
+		colorFlip(root)
+	}
+	return root
+}
+
+// Clear deletes all the tree’s key-value items. If [Reserve] has been
+// called, Clear resets the tree but keeps its capacity: the cleared nodes
+// are kept for reuse by later insertions, so a tree that is repeatedly
+// filled and cleared needn’t keep reallocating (but Clear is O(n) rather
+// than O(1)). The kept nodes’ keys and values are zeroed, so anything
+// they referred to (e.g., large slices) can be garbage collected. (If
+// Reserve hasn’t been called, the cleared nodes are themselves garbage.)
+// To free the memory instead, use [Release].
+// See also [Delete]
+func (me *SortedMap[K, V]) Clear() {
+	if me.hooks.watchesDeletes() {
+		me.Drain()
+	} else {
+		me.clear()
+	}
+}
+
+// clear empties the tree, recycling its nodes if pooled, without calling
+// any hooks.
+func (me *SortedMap[K, V]) clear() {
+	if me.pooled && !me.shared {
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			me.recycle(root)
+		}
+	}
+	me.root = nil
+	me.size = 0
+	me.shared = false
+}
+
+// recycle zeroes the node, so that it no longer keeps its key and value
+// reachable, and adds it to the free nodes for reuse. The node must
+// already have been returned by an [inOrder] stack’s next().
+func (me *SortedMap[K, V]) recycle(root *node[K, V]) {
+	*root = node[K, V]{}
+	me.free = append(me.free, root)
+}
+
+// Drain returns all the tree’s key-value items in key order and deletes
+// them from the tree, all in a single traversal. Afterwards the tree is
+// empty as if [Clear] had been called, and holds no references to the
+// returned keys and values. For example:
+//
+//	batch := queue.Drain()
+func (me *SortedMap[K, V]) Drain() []Item[K, V] {
+	items := make([]Item[K, V], 0, me.size)
+	var stack inOrder[K, V]
+	stack.pushLeft(me.root)
+	for root := stack.next(); root != nil; root = stack.next() {
+		items = append(items, Item[K, V]{root.key, root.value})
+		if me.pooled && !me.shared {
+			me.recycle(root)
+		}
+	}
+	me.root = nil
+	me.size = 0
+	me.shared = false
+	for _, item := range items {
+		me.hooks.deleted(item.Key, item.Value)
+	}
+	return items
+}
+
+// Release deletes all the tree’s key-value items and releases all its
+// memory, including any reserved or recycled nodes, for the garbage
+// collector. It also undoes the effect of [Reserve], so [Clear] no longer
+// keeps capacity (until Reserve is called again). This is O(1) (unless
+// there is an OnDelete hook; see [Hooks]).
+// See also [Clear]
+func (me *SortedMap[K, V]) Release() {
+	if me.hooks.watchesDeletes() {
+		me.pooled = false
+		me.Drain()
+	}
+	me.root = nil
+	me.size = 0
+	me.pool = nil
+	me.free = nil
+	me.pooled = false
+	me.shared = false
+}
+
+// MapValues returns a new SortedMap with the same keys as the given tree
+// and with values produced by calling fn on each key-value pair (in key
+// order). For example:
+//
+//	names := MapValues(users, func(_ string, u User) string {
+//		return u.Name
+//	})
+//
+// Since the keys are unchanged, the tree’s structure is copied directly
+// rather than reinserted.
+func MapValues[K Ordered, V, V2 any](tree *SortedMap[K, V],
+	fn func(K, V) V2,
+) *SortedMap[K, V2] {
+	return &SortedMap[K, V2]{root: mapValues(tree.root, fn),
+		size: tree.size}
+}
+
+// Clone returns a new SortedMap with the same key-value items (and
+// duplicate-key policy) as this tree and sharing no nodes with it
+// (although values are copied shallowly). The tree’s structure is copied
+// directly so this is O(n).
+// See also [CloneFunc]
+func (me *SortedMap[K, V]) Clone() *SortedMap[K, V] {
+	clone := MapValues(me, func(_ K, value V) V { return value })
+	clone.onDuplicate = me.onDuplicate
+	return clone
+}
+
+// CloneFunc returns a new SortedMap like [Clone] but with each value
+// replaced by copyValue(value). Use this when the values are slices,
+// maps, or pointers so that the clone shares no mutable state with this
+// tree. For example:
+//
+//	clone := tree.CloneFunc(slices.Clone)
+func (me *SortedMap[K, V]) CloneFunc(copyValue func(V) V) *SortedMap[K, V] {
+	clone := MapValues(me, func(_ K, value V) V { return copyValue(value) })
+	clone.onDuplicate = me.onDuplicate
+	return clone
+}
+
+func mapValues[K Ordered, V, V2 any](root *node[K, V],
+	fn func(K, V) V2,
+) *node[K, V2] {
+	if root == nil {
+		return nil
+	}
+	left := mapValues(root.left, fn)
+	value := fn(root.key, root.value)
+	return &node[K, V2]{key: root.key, value: value, red: root.red,
+		size: root.size, left: left, right: mapValues(root.right, fn)}
+}
+
+// Height returns the number of nodes on the tree’s longest root-to-leaf
+// path (0 for an empty tree). For a red-black tree this should never
+// exceed 2·log₂(n+1). Height is O(n) and is intended for diagnostics
+// (e.g., in tests and benchmarks) rather than for hot paths.
+func (me *SortedMap[K, V]) Height() int { return height(me.root) }
+
+func height[K Ordered, V any](root *node[K, V]) int {
+	if root == nil {
+		return 0
+	}
+	return 1 + max(height(root.left), height(root.right))
+}
+
+// Stats returns the number of nodes in the tree (always the same as
+// [Len]), its [Height], and its black-height, i.e., the number of black
+// nodes on every path from the root to a leaf. For a balanced tree the
+// height is at most twice the black-height. Like Height, Stats is O(n)
+// and is intended for diagnostics.
+func (me *SortedMap[K, V]) Stats() (nodes, height, blackHeight int) {
+	for root := me.root; root != nil; root = root.left {
+		if !isRed(root) {
+			blackHeight++
+		}
+	}
+	return me.size, me.Height(), blackHeight
+}
+
+// CheckInvariants returns nil if the tree is a valid left-leaning
+// red-black tree; otherwise it returns an error describing the first
+// violation found. The properties checked are: the root is black; no red
+// node has a red child; no node has a red right child; every path from
+// the root to a nil link passes through the same number of black nodes;
+// the keys are in strictly ascending in-order; each node’s subtree size
+// is correct; and the number of nodes matches [Len]. This is O(n) and
+// intended for tests and debugging.
+func (me *SortedMap[K, V]) CheckInvariants() error {
+	if isRed(me.root) {
+		return errors.New("root is red")
+	}
+	var previous *node[K, V]
+	count := 0
+	if _, err := checkInvariants(me.root, &previous, &count); err != nil {
+		return err
+	}
+	if count != me.size {
+		return fmt.Errorf("tree has %d nodes but Len is %d", count,
+			me.size)
+	}
+	return nil
+}
+
+func checkInvariants[K Ordered, V any](root *node[K, V],
+	previous **node[K, V], count *int,
+) (int, error) {
+	if root == nil {
+		return 1, nil
+	}
+	if root.red && (isRed(root.left) || isRed(root.right)) {
+		return 0, fmt.Errorf("red node %v has a red child", root.key)
+	}
+	if isRed(root.right) {
+		return 0, fmt.Errorf("node %v has a red right child", root.key)
+	}
+	leftHeight, err := checkInvariants(root.left, previous, count)
+	if err != nil {
+		return 0, err
+	}
+	if *previous != nil && !((*previous).key < root.key) {
+		return 0, fmt.Errorf("key %v is not greater than preceding key %v",
+			root.key, (*previous).key)
+	}
+	*previous = root
+	*count++
+	rightHeight, err := checkInvariants(root.right, previous, count)
+	if err != nil {
+		return 0, err
+	}
+	if root.size != 1+sizeOf(root.left)+sizeOf(root.right) {
+		return 0, fmt.Errorf("node %v has size %d but its subtree has %d "+
+			"nodes", root.key, root.size,
+			1+sizeOf(root.left)+sizeOf(root.right))
+	}
+	if leftHeight != rightHeight {
+		return 0, fmt.Errorf("node %v has black heights %d (left) and %d "+
+			"(right)", root.key, leftHeight, rightHeight)
+	}
+	if !root.red {
+		leftHeight++
+	}
+	return leftHeight, nil
+}
+
+// Difference returns a new SortedMap containing those of this tree’s
+// key-value items whose keys are not in the other tree. For example:
+//
+//	added := current.Difference(snapshot)
+//
+// This is O(n+m) since it merges in-order walks of both trees and then
+// builds the result directly. Neither tree is changed, and the result
+// shares no nodes with either (although values are copied shallowly).
+func (me *SortedMap[K, V]) Difference(
+	other *SortedMap[K, V],
+) *SortedMap[K, V] {
+	items := make([]Item[K, V], 0, me.size)
+	var mine, theirs inOrder[K, V]
+	mine.pushLeft(me.root)
+	theirs.pushLeft(other.root)
+	b := theirs.next()
+	for a := mine.next(); a != nil; a = mine.next() {
+		for b != nil && b.key < a.key {
+			b = theirs.next()
+		}
+		if b == nil || a.key < b.key {
+			items = append(items, Item[K, V]{a.key, a.value})
+		}
+	}
+	return fromSorted(items)
+}
+
+// Union returns a new SortedMap containing all the key-value items of
+// this tree and of the other tree. Where both trees have the same key, the
+// value is onConflict(thisValue, otherValue), or if onConflict is nil,
+// otherValue. For example:
+//
+//	totals := a.Union(&b, func(x, y int) int { return x + y })
+//
+// This is O(n+m) since it merges in-order walks of both trees and then
+// builds the result directly. Neither tree is changed, and the result
+// shares no nodes with either (although values are copied shallowly).
+func (me *SortedMap[K, V]) Union(other *SortedMap[K, V],
+	onConflict func(a, b V) V,
+) *SortedMap[K, V] {
+	items := make([]Item[K, V], 0, me.size+other.size)
+	var mine, theirs inOrder[K, V]
+	mine.pushLeft(me.root)
+	theirs.pushLeft(other.root)
+	a, b := mine.next(), theirs.next()
+	for a != nil || b != nil {
+		switch {
+		case b == nil || (a != nil && a.key < b.key):
+			items = append(items, Item[K, V]{a.key, a.value})
+			a = mine.next()
+		case a == nil || b.key < a.key:
+			items = append(items, Item[K, V]{b.key, b.value})
+			b = theirs.next()
+		default:
+			value := b.value
+			if onConflict != nil {
+				value = onConflict(a.value, b.value)
+			}
+			items = append(items, Item[K, V]{a.key, value})
+			a, b = mine.next(), theirs.next()
+		}
+	}
+	return fromSorted(items)
+}
+
+// IntersectionKeys is a range function for use as an iterable in a
+// for … range loop that returns, in order, the keys that are in both this
+// tree and the other tree. For example:
+//
+//	for key := range tree.IntersectionKeys(&other)
+//
+// This is O(n+m) since it merges in-order walks of both trees, and it
+// builds no new tree.
+// See also [Difference] and [Union]
+func (me *SortedMap[K, V]) IntersectionKeys(
+	other *SortedMap[K, V],
+) iter.Seq[K] {
+	return func(yield func(K) bool) {
+		var mine, theirs inOrder[K, V]
+		mine.pushLeft(me.root)
+		theirs.pushLeft(other.root)
+		a, b := mine.next(), theirs.next()
+		for a != nil && b != nil {
+			switch {
+			case a.key < b.key:
+				a = mine.next()
+			case b.key < a.key:
+				b = theirs.next()
+			default:
+				if !yield(a.key) {
+					return
+				}
+				a, b = mine.next(), theirs.next()
+			}
+		}
+	}
+}
+
+// Equal returns true if the two trees have the same keys and, for each
+// key, values that are equal using ==; otherwise returns false. For
+// example:
+//
+//	if !Equal(&cached, &current) { … }
+//
+// This is O(n) (or O(1) if the sizes differ) since it walks both trees
+// in order together. (The [SortedMap.Equal] method compares keys only.)
+// See also [Diff]
+func Equal[K Ordered, V comparable](a, b *SortedMap[K, V]) bool {
+	if a.size != b.size {
+		return false
+	}
+	var mine, theirs inOrder[K, V]
+	mine.pushLeft(a.root)
+	theirs.pushLeft(b.root)
+	for x := mine.next(); x != nil; x = mine.next() {
+		y := theirs.next()
+		if x.key != y.key || x.value != y.value {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare returns -1, 0, or 1 depending on whether this tree is less
+// than, equal to, or greater than the other tree, comparing their
+// sequences of key-value items lexicographically in key order. At the
+// first position where the trees differ, the tree with the smaller key
+// is less; or if the keys are equal, the tree whose value is less
+// according to cmpVal (which must return a negative number, 0, or a
+// positive number, like [cmp.Compare]) is less. If every item matches but
+// one tree has more items, the shorter tree is less; otherwise the trees
+// are equal. For example:
+//
+//	slices.SortFunc(trees, func(a, b *SortedMap[string, int]) int {
+//		return a.Compare(b, cmp.Compare)
+//	})
+func (me *SortedMap[K, V]) Compare(other *SortedMap[K, V],
+	cmpVal func(a, b V) int,
+) int {
+	var mine, theirs inOrder[K, V]
+	mine.pushLeft(me.root)
+	theirs.pushLeft(other.root)
+	for {
+		a, b := mine.next(), theirs.next()
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		case b == nil:
+			return 1
+		case a.key < b.key:
+			return -1
+		case a.key > b.key:
+			return 1
+		}
+		if c := cmpVal(a.value, b.value); c != 0 {
+			if c < 0 {
+				return -1
+			}
+			return 1
+		}
+	}
+}
+
+// DiffKind identifies the change to a key reported by [Diff].
+type DiffKind uint8
+
+const (
+	Added   DiffKind = iota // the key is only in the newer tree
+	Removed                 // the key is only in the older tree
+	Changed                 // the key is in both trees with unequal values
+)
+
+func (me DiffKind) String() string {
+	switch me {
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Changed:
+		return "Changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", uint8(me))
+}
+
+// Diff is a range function for use as an iterable in a for … range loop
+// that returns, in key order, each key whose item must be added, removed,
+// or changed to turn the older tree into the newer one. Values are
+// compared using eq; keys present in both trees with equal values are
+// skipped. For example:
+//
+//	for key, kind := range sortedmap.Diff(&before, &after, eq)
+//
+// This is O(n+m) since it merges in-order walks of both trees.
+func Diff[K Ordered, V any](older, newer *SortedMap[K, V],
+	eq func(a, b V) bool,
+) iter.Seq2[K, DiffKind] {
+	return func(yield func(K, DiffKind) bool) {
+		var olds, news inOrder[K, V]
+		olds.pushLeft(older.root)
+		news.pushLeft(newer.root)
+		a, b := olds.next(), news.next()
+		for a != nil || b != nil {
+			switch {
+			case b == nil || (a != nil && a.key < b.key):
+				if !yield(a.key, Removed) {
+					return
+				}
+				a = olds.next()
+			case a == nil || b.key < a.key:
+				if !yield(b.key, Added) {
+					return
+				}
+				b = news.next()
+			default:
+				if !eq(a.value, b.value) && !yield(a.key, Changed) {
+					return
+				}
+				a, b = olds.next(), news.next()
+			}
+		}
+	}
+}
+
+// Page returns up to limit items whose keys are > after in key order,
+// the key to pass as after to get the next page, and true if there are
+// more items after this page. Use [FirstPage] to get the first page. For
+// example:
+//
+//	items, after, more := tree.FirstPage(20)
+//	for more {
+//		items, after, more = tree.Page(after, 20)
+//	}
+//
+// If there are no items after the given key (or limit < 1), Page returns
+// no items, the given after key, and false (or, if limit < 1, true if
+// there are items after the given key). The last page returns its items
+// (its last key as the next after) and false. Finding the start of a page
+// is O(log n) so earlier pages are never rescanned.
+func (me *SortedMap[K, V]) Page(after K, limit int) ([]Item[K, V], K,
+	bool,
+) {
+	var stack inOrder[K, V]
+	stack.pushAfter(me.root, after)
+	return page(&stack, after, limit)
+}
+
+// FirstPage returns up to limit items starting from the smallest key; see
+// [Page] for details.
+func (me *SortedMap[K, V]) FirstPage(limit int) ([]Item[K, V], K, bool) {
+	var stack inOrder[K, V]
+	stack.pushLeft(me.root)
+	var zero K
+	return page(&stack, zero, limit)
+}
+
+func page[K Ordered, V any](stack *inOrder[K, V], after K,
+	limit int,
+) ([]Item[K, V], K, bool) {
+	var items []Item[K, V]
+	root := stack.next()
+	for ; root != nil && len(items) < limit; root = stack.next() {
+		items = append(items, Item[K, V]{root.key, root.value})
+		after = root.key
+	}
+	return items, after, root != nil
+}
+
+// Rank returns the number of keys in the tree that are less than the
+// given key, i.e., the zero-based position the key has (or would have if
+// it were inserted) in key order. This is O(log n).
+// See also [CountLess] and [CountGreater]
+func (me *SortedMap[K, V]) Rank(key K) int {
+	rank, _ := me.rank(key)
+	return rank
+}
+
+// FindMany returns two new slices, each the same length as keys: the
+// values for each of the keys (or V’s zero value for those not in the
+// tree), and whether each key was found. For example:
+//
+//	names, found := users.FindMany(ids)
+//
+// See also [Find] and [ContainsAll]
+func (me *SortedMap[K, V]) FindMany(keys []K) ([]V, []bool) {
+	values := make([]V, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		if root := me.findNode(key); root != nil {
+			values[i], found[i] = root.value, true
+		}
+	}
+	return values, found
+}
+
+// FindWithRank returns the value with the given key, the key’s zero-based
+// position in key order (see [Rank]), and true; or V’s zero value, the
+// rank the key would have if it were inserted, and false if the key isn’t
+// in the tree. For example:
+//
+//	if value, rank, ok := tree.FindWithRank(key); ok {
+//		fmt.Printf("%v is #%d of %d\n", value, rank+1, tree.Len())
+//	}
+//
+// This takes a single O(log n) descent of the tree.
+// See also [Find]
+func (me *SortedMap[K, V]) FindWithRank(key K) (V, int, bool) {
+	rank, root := me.rank(key)
+	if root == nil {
+		var zero V
+		return zero, rank, false
+	}
+	return root.value, rank, true
+}
+
+// FloorRank returns the key and value of the item with the largest key
+// that is <= the given key, that item’s zero-based position in key order
+// (see [Rank]), and true; or K’s and V’s zero values, 0, and false if
+// every key is greater than the given key (or the tree is empty). For
+// example:
+//
+//	_, _, rank, ok := tree.FloorRank(score) // where score falls
+//
+// This takes a single O(log n) descent of the tree.
+// See also [FindWithRank]
+func (me *SortedMap[K, V]) FloorRank(key K) (K, V, int, bool) {
+	var floor *node[K, V]
+	rank, floorRank := 0, 0
+	for root := me.root; root != nil; {
+		if key < root.key {
+			root = root.left
+		} else {
+			floor, floorRank = root, rank+sizeOf(root.left)
+			if key == root.key {
+				break
+			}
+			rank = floorRank + 1
+			root = root.right
+		}
+	}
+	if floor == nil {
+		var zeroKey K
+		var zeroValue V
+		return zeroKey, zeroValue, 0, false
+	}
+	return floor.key, floor.value, floorRank, true
+}
+
+// rank returns the number of keys less than key and the node with the
+// key, or nil if the key isn’t present.
+func (me *SortedMap[K, V]) rank(key K) (int, *node[K, V]) {
+	rank := 0
+	for root := me.root; root != nil; {
+		if key < root.key {
+			root = root.left
+		} else if key > root.key {
+			rank += sizeOf(root.left) + 1
+			root = root.right
+		} else {
+			return rank + sizeOf(root.left), root
+		}
+	}
+	return rank, nil
+}
+
+// Count returns the number of keys in the half-open range [lo, hi) (0 if
+// lo >= hi). This is O(log n) since it is computed from two ranks without
+// visiting the keys in the range.
+// See also [Range] and [Rank]
+func (me *SortedMap[K, V]) Count(lo, hi K) int {
+	if !(lo < hi) {
+		return 0
+	}
+	return me.Rank(hi) - me.Rank(lo)
+}
+
+// Histogram returns the number of keys in each of the half-open buckets
+// [edges[0], edges[1]), [edges[1], edges[2]), and so on, so the result
+// has len(edges)-1 counts (or none if there are fewer than two edges).
+// Keys less than the first edge or >= the last edge aren’t counted; use
+// [CountLess] and [CountGreater] (or [Count]) for these if needed. For
+// example:
+//
+//	counts := tree.Histogram([]int{0, 10, 20, 30})
+//
+// This is O(b log n) for b edges since it computes each edge’s [Rank]
+// without visiting the keys. Histogram panics if the edges aren’t in
+// ascending order.
+func (me *SortedMap[K, V]) Histogram(edges []K) []int {
+	counts := make([]int, max(0, len(edges)-1))
+	if len(edges) == 0 {
+		return counts
+	}
+	previous := me.Rank(edges[0])
+	for i, edge := range edges[1:] {
+		if edge < edges[i] {
+			panic(fmt.Sprintf("sortedmap: histogram edge %v at index %d "+
+				"is less than the previous edge %v", edge, i+1, edges[i]))
+		}
+		rank := me.Rank(edge)
+		counts[i] = rank - previous
+		previous = rank
+	}
+	return counts
 }
 
-func deleteRight[K Comparable, V any](root *node[K, V], key K) (
-	*node[K, V], bool,
+// CountLess returns the number of keys in the tree that are less than the
+// given key (which need not be present). This is O(log n).
+// See also [CountGreater] and [Rank]
+func (me *SortedMap[K, V]) CountLess(key K) int { return me.Rank(key) }
+
+// CountGreater returns the number of keys in the tree that are greater
+// than the given key (which need not be present). This is O(log n).
+// See also [CountLess] and [Rank]
+func (me *SortedMap[K, V]) CountGreater(key K) int {
+	rank, root := me.rank(key)
+	if root != nil {
+		rank++
+	}
+	return me.size - rank
+}
+
+// Select returns the key and value of the item at the given zero-based
+// position in key order, and true; or K’s and V’s zero values and false
+// if the index is out of range. This is O(log n). For example:
+//
+//	key, value, ok := tree.Select(tree.Len() / 2) // median
+//
+// See also [Rank]
+func (me *SortedMap[K, V]) Select(index int) (K, V, bool) {
+	if root := me.selectNode(index); root != nil {
+		return root.key, root.value, true
+	}
+	var key K
+	var value V
+	return key, value, false
+}
+
+// SelectFromEnd returns the key and value of the item at the given
+// zero-based position counting back from the largest key (so 0 is the
+// largest, 1 the second largest, and so on), and true; or K’s and V’s
+// zero values and false if the index is out of range. This is O(log n).
+// For example:
+//
+//	key, value, ok := tree.SelectFromEnd(2) // third largest
+//
+// See also [Select]
+func (me *SortedMap[K, V]) SelectFromEnd(index int) (K, V, bool) {
+	if index < 0 {
+		var key K
+		var value V
+		return key, value, false
+	}
+	return me.Select(me.size - 1 - index)
+}
+
+// NextN returns the key and value of the item n positions after the
+// first item whose key is greater than the given key, and true; or K’s
+// and V’s zero values and false if there is no such item. Whether or not
+// the key is in the tree, NextN(key, 0) returns the item with the
+// smallest key > key, and NextN(key, 1) the one after that. A negative n
+// counts back from there, so NextN(key, -1) returns the item with the
+// largest key <= key. For example:
+//
+//	key, value, ok := tree.NextN(topRowKey, 100) // jump 100 rows down
+//
+// This is O(log n) since it combines [Rank] and [Select].
+func (me *SortedMap[K, V]) NextN(key K, n int) (K, V, bool) {
+	rank, root := me.rank(key)
+	if root != nil {
+		rank++
+	}
+	if n < -me.size || n > me.size {
+		return me.Select(-1)
+	}
+	return me.Select(rank + n)
+}
+
+// At returns the key and value of the item at the given zero-based
+// position in key order. Like slice indexing, At panics if the index is
+// out of range, so it is only suitable when the index is known to be
+// valid (i.e., 0 <= index < Len()). This is O(log n). For example:
+//
+//	key, value := tree.At(0) // smallest key (panics if tree is empty)
+//
+// See also [Select]
+func (me *SortedMap[K, V]) At(index int) (K, V) {
+	root := me.selectNode(index)
+	if root == nil {
+		panic(fmt.Sprintf("sortedmap: index out of range [%d] with "+
+			"length %d", index, me.size))
+	}
+	return root.key, root.value
+}
+
+// KeyAt returns the key of the item at the given zero-based position in
+// key order and true, or K’s zero value and false if the index is out of
+// range. This is O(log n).
+// See also [ValueAt] and [Select]
+func (me *SortedMap[K, V]) KeyAt(index int) (K, bool) {
+	if root := me.selectNode(index); root != nil {
+		return root.key, true
+	}
+	var zero K
+	return zero, false
+}
+
+// ValueAt returns the value of the item at the given zero-based position
+// in key order and true, or V’s zero value and false if the index is out
+// of range. This is O(log n).
+// See also [KeyAt] and [Select]
+func (me *SortedMap[K, V]) ValueAt(index int) (V, bool) {
+	if root := me.selectNode(index); root != nil {
+		return root.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (me *SortedMap[K, V]) selectNode(index int) *node[K, V] {
+	if index < 0 || index >= me.size {
+		return nil
+	}
+	root := me.root
+	for {
+		size := sizeOf(root.left)
+		if index < size {
+			root = root.left
+		} else if index > size {
+			index -= size + 1
+			root = root.right
+		} else {
+			return root
+		}
+	}
+}
+
+// Percentile returns the key and value of the item at the given
+// percentile p (from 0.0 to 1.0) using the nearest-rank method, and true;
+// or K’s and V’s zero values and false if the tree is empty. Values of p
+// outside the range are clamped to it. This is O(log n). For example:
+//
+//	key, value, ok := tree.Percentile(0.95)
+func (me *SortedMap[K, V]) Percentile(p float64) (K, V, bool) {
+	if !(p > 0) { // also handles NaN
+		p = 0
+	} else if p > 1 {
+		p = 1
+	}
+	index := int(math.Ceil(p*float64(me.size))) - 1 // rank is 1-based
+	return me.Select(max(index, 0))
+}
+
+// Walk calls fn on each of the tree’s key-value items in key order,
+// stopping at and returning the first error fn returns (or nil if there
+// are none). For example:
+//
+//	err := tree.Walk(func(key string, value int) error {
+//		_, err := fmt.Fprintf(writer, "%s\t%d\n", key, value)
+//		return err
+//	})
+//
+// See also [All]
+func (me *SortedMap[K, V]) Walk(fn func(K, V) error) error {
+	for key, value := range me.All() {
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Debug is a range function for use as an iterable in a for … range loop
+// that returns each of the tree’s key-value items in key order together
+// with whether its node is red (true) or black (false), e.g.,
+//
+//	for item, red := range tree.Debug()
+//
+// This exposes the tree’s internal coloring, which depends on the
+// balancing algorithm and may change in future versions, so it is only
+// intended for debugging, visualization, and teaching.
+// See also [LevelOrder] and [CheckInvariants]
+func (me *SortedMap[K, V]) Debug() iter.Seq2[Item[K, V], bool] {
+	return func(yield func(Item[K, V], bool) bool) {
+		var stack inOrder[K, V]
+		stack.pushLeft(me.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			if !yield(Item[K, V]{root.key, root.value}, root.red) {
+				return
+			}
+		}
+	}
+}
+
+// LevelOrder is a range function for use as an iterable in a
+// for … range loop that returns each of the tree’s nodes breadth-first
+// (i.e., level by level from the root, and left to right within each
+// level) as its depth (0 for the root) and its key-value item, e.g.,
+//
+//	for depth, item := range tree.LevelOrder()
+//
+// This exposes the tree’s internal structure and is intended for
+// debugging, visualization, and teaching.
+func (me *SortedMap[K, V]) LevelOrder() iter.Seq2[int, Item[K, V]] {
+	type entry struct {
+		root  *node[K, V]
+		depth int
+	}
+	return func(yield func(int, Item[K, V]) bool) {
+		if me.root == nil {
+			return
+		}
+		queue := []entry{{me.root, 0}}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			if !yield(current.depth, Item[K, V]{current.root.key,
+				current.root.value}) {
+				return
+			}
+			if current.root.left != nil {
+				queue = append(queue, entry{current.root.left,
+					current.depth + 1})
+			}
+			if current.root.right != nil {
+				queue = append(queue, entry{current.root.right,
+					current.depth + 1})
+			}
+		}
+	}
+}
+
+// Any returns true if pred returns true for at least one of the tree’s
+// key-value items, stopping at the first one that does; it returns false
+// for an empty tree.
+// See also [Every]
+func (me *SortedMap[K, V]) Any(pred func(K, V) bool) bool {
+	for key, value := range me.All() {
+		if pred(key, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Every returns true if pred returns true for all of the tree’s
+// key-value items, stopping at the first one that doesn’t; it returns true
+// for an empty tree.
+// See also [Any]
+func (me *SortedMap[K, V]) Every(pred func(K, V) bool) bool {
+	for key, value := range me.All() {
+		if !pred(key, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Partition returns two new SortedMaps: matched, holding those of the
+// tree’s key-value items for which pred returns true, and rest, holding
+// all the others. The tree itself is unchanged. For example:
+//
+//	valid, invalid := tree.Partition(func(_ string, r Record) bool {
+//		return r.IsValid()
+//	})
+//
+// This is O(n) since it takes a single in-order pass and then builds
+// both results directly. The results share no nodes with the tree
+// (although values are copied shallowly).
+// See also [DeleteFunc]
+func (me *SortedMap[K, V]) Partition(pred func(K, V) bool) (matched,
+	rest *SortedMap[K, V],
 ) {
-	deleted := false
-	if !isRed(root.right) && !isRed(root.right.left) {
-		root = moveRedRight(root)
+	var yes, no []Item[K, V]
+	for key, value := range me.All() {
+		if pred(key, value) {
+			yes = append(yes, Item[K, V]{key, value})
+		} else {
+			no = append(no, Item[K, V]{key, value})
+		}
 	}
-	if key == root.key {
-		smallest := first(root.right)
-		root.key = smallest.key
-		root.value = smallest.value
-		root.right = deleteMinimum(root.right)
-		deleted = true
-	} else {
-		root.right, deleted = delete_(root.right, key)
+	return fromSorted(yes), fromSorted(no)
+}
+
+// CountFunc returns how many of the tree’s key-value items pred returns
+// true for, calling pred on each item in key order.
+// See also [Any], [Every], and [DeleteFunc]
+func (me *SortedMap[K, V]) CountFunc(pred func(K, V) bool) int {
+	count := 0
+	for key, value := range me.All() {
+		if pred(key, value) {
+			count++
+		}
 	}
-	return root, deleted
+	return count
 }
 
-func moveRedRight[K Comparable, V any](
-	root *node[K, V],
-) *node[K, V] {
-	colorFlip(root)
-	if root.left != nil && isRed(root.left.left) {
-		root = rotateRight(root)
-		colorFlip(root)
+// Reduce returns the result of calling fn on each of the tree’s key-value
+// items in key order, passing the accumulated result so far (starting
+// with init) and returning the new accumulated result. For an empty tree
+// it returns init. For example:
+//
+//	total := Reduce(&tree, 0, func(sum int, _ string, value int) int {
+//		return sum + value
+//	})
+//
+// Since the order is always the same, so is the result (unlike folding
+// over a built-in map).
+func Reduce[K Ordered, V, A any](tree *SortedMap[K, V], init A,
+	fn func(acc A, key K, value V) A,
+) A {
+	acc := init
+	for key, value := range tree.All() {
+		acc = fn(acc, key, value)
 	}
-	return root
+	return acc
 }
 
-// We do not provide an exported First() method because this
-// is an implementation detail.
-func first[K Comparable, V any](root *node[K, V]) *node[K, V] {
-	for root.left != nil {
-		root = root.left
+// Sum returns the total of all the tree’s values, added in key order, or
+// 0 for an empty tree. For example:
+//
+//	total := Sum(&wordCounts)
+//
+// See also [Reduce]
+func Sum[K Ordered, V Number](tree *SortedMap[K, V]) V {
+	var total V
+	for value := range tree.Values() {
+		total += value
 	}
-	return root
+	return total
 }
 
-func deleteMinimum[K Comparable, V any](
-	root *node[K, V],
-) *node[K, V] {
-	if root.left == nil {
-		// free(root)
+// SumMerge returns a new SortedMap holding every key that is in any of
+// the given trees, each with the sum of its values across the trees. For
+// example, to combine per-shard counts:
+//
+//	totals := SumMerge(&shard1, &shard2, &shard3)
+//
+// The trees are merged in a single pass over their in-order walks and
+// the result is built directly, so this is O(n·k) for n items in total
+// across k trees. None of the trees is changed and the result shares no
+// nodes with them.
+// See also [Sum] and [Union]
+func SumMerge[K Ordered, V Number](trees ...*SortedMap[K, V],
+) *SortedMap[K, V] {
+	stacks := make([]inOrder[K, V], len(trees))
+	heads := make([]*node[K, V], len(trees))
+	total := 0
+	for i, tree := range trees {
+		stacks[i].pushLeft(tree.root)
+		heads[i] = stacks[i].next()
+		total += tree.size
+	}
+	items := make([]Item[K, V], 0, total)
+	for {
+		var smallest *node[K, V]
+		for _, head := range heads {
+			if head != nil && (smallest == nil || head.key < smallest.key) {
+				smallest = head
+			}
+		}
+		if smallest == nil {
+			return fromSorted(items)
+		}
+		item := Item[K, V]{Key: smallest.key}
+		for i, head := range heads {
+			if head != nil && head.key == item.Key {
+				item.Value += head.value
+				heads[i] = stacks[i].next()
+			}
+		}
+		items = append(items, item)
+	}
+}
+
+// ValueStats returns the smallest and largest of the tree’s values (not
+// keys), the number of values, and true; or zeros and false for an empty
+// tree. For example:
+//
+//	low, high, count, ok := ValueStats(&readings)
+//
+// This takes a single in-order pass. Floating-point NaN values are
+// counted but never chosen as the smallest or largest unless every value
+// is NaN.
+// See also [Sum]
+func ValueStats[K Ordered, V Number](tree *SortedMap[K, V]) (minimum,
+	maximum V, count int, ok bool,
+) {
+	for value := range tree.Values() {
+		if count == 0 || value < minimum || minimum != minimum {
+			minimum = value
+		}
+		if count == 0 || value > maximum || maximum != maximum {
+			maximum = value
+		}
+		count++
+	}
+	return minimum, maximum, count, count > 0
+}
+
+// GroupBy returns a new SortedMap whose keys are the distinct group keys
+// produced by calling keyFn on each of the tree’s key-value items, and
+// whose values are the values in each group, in the order of their
+// original keys. For example:
+//
+//	byInitial := GroupBy(&words, func(word string, _ int) byte {
+//		return word[0]
+//	})
+//
+// Since both the groups and the values within them are ordered, the
+// result is deterministic (unlike grouping into a built-in map).
+func GroupBy[K Ordered, V any, G Ordered](tree *SortedMap[K, V],
+	keyFn func(K, V) G,
+) *SortedMap[G, []V] {
+	var groups SortedMap[G, []V]
+	for key, value := range tree.All() {
+		group := keyFn(key, value)
+		if root := groups.findNode(group); root != nil {
+			root.value = append(root.value, value)
+		} else {
+			groups.Insert(group, []V{value})
+		}
+	}
+	return &groups
+}
+
+// Invert returns a new SortedMap whose keys are the given tree’s
+// distinct values and whose values are the keys that hold each value, in
+// ascending order. For example, for reverse lookups:
+//
+//	idsByName := Invert(&namesByID)
+//	ids, ok := idsByName.Find("Ada")
+func Invert[K, V Ordered](tree *SortedMap[K, V]) *SortedMap[V, []K] {
+	var inverted SortedMap[V, []K]
+	for key, value := range tree.All() {
+		if root := inverted.findNode(value); root != nil {
+			root.value = append(root.value, key)
+		} else {
+			inverted.Insert(value, []K{key})
+		}
+	}
+	return &inverted
+}
+
+// PrefixGroups is a range function for use as an iterable in a
+// for … range loop that returns, in order, each distinct top-level
+// prefix of the tree’s string keys. A key containing sep contributes its
+// prefix up to and including the first sep; a key without sep contributes
+// itself. For example, given the keys docs/a.txt, docs/b/c.txt, readme,
+// and src/main.go, with a sep of '/', the prefixes are docs/, readme, and
+// src/:
+//
+//	for prefix := range PrefixGroups(&files, '/')
+//
+// After each prefix ending with sep, the iteration seeks past every other
+// key with that prefix, so large groups are skipped in O(log n) rather
+// than visited.
+func PrefixGroups[K ~string, V any](tree *SortedMap[K, V],
+	sep byte,
+) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		var stack inOrder[K, V]
+		stack.pushLeft(tree.root)
+		for root := stack.next(); root != nil; root = stack.next() {
+			key := string(root.key)
+			i := strings.IndexByte(key, sep)
+			if i == -1 {
+				if !yield(key) {
+					return
+				}
+				continue
+			}
+			prefix := key[:i+1]
+			if !yield(prefix) {
+				return
+			}
+			end, ok := prefixEnd(prefix)
+			if !ok {
+				return
+			}
+			stack.depth = 0
+			stack.pushFrom(tree.root, K(end))
+		}
+	}
+}
+
+// PrefixCount returns how many of the tree’s string keys start with the
+// given prefix (all of them if the prefix is empty). For example, for an
+// autocomplete “N matches” label:
+//
+//	count := PrefixCount(&words, typed)
+//
+// This is O(log n) since it is computed from the ranks of the prefix and
+// of the smallest string greater than every string with the prefix (the
+// prefix with its last non-0xFF byte incremented and anything after that
+// byte dropped), without visiting the matching keys.
+// See also [PrefixGroups] and [Count]
+func PrefixCount[K ~string, V any](tree *SortedMap[K, V],
+	prefix string,
+) int {
+	start := tree.Rank(K(prefix))
+	if end, ok := prefixEnd(prefix); ok {
+		return tree.Rank(K(end)) - start
+	}
+	return tree.size - start
+}
+
+// prefixEnd returns the smallest string that is greater than every string
+// with the given prefix, and true; or "" and false if there is no such
+// string (i.e., if the prefix is empty or all 0xFF bytes).
+func prefixEnd(prefix string) (string, bool) {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			return prefix[:i] + string([]byte{prefix[i] + 1}), true
+		}
+	}
+	return "", false
+}
+
+// Split returns two new SortedMaps, the first holding this tree’s
+// key-value items whose keys are less than the given key, and the second
+// holding those whose keys are >= the given key. For example:
+//
+//	lower, upper := tree.Split(pivot)
+//
+// This tree is left unchanged, and the sum of the two new trees’ sizes is
+// equal to its size. This is O(n) since it walks this tree and then builds
+// the new trees directly.
+func (me *SortedMap[K, V]) Split(key K) (*SortedMap[K, V],
+	*SortedMap[K, V],
+) {
+	rank := me.Rank(key)
+	left := make([]Item[K, V], 0, rank)
+	right := make([]Item[K, V], 0, me.size-rank)
+	for k, value := range me.All() {
+		if k < key {
+			left = append(left, Item[K, V]{k, value})
+		} else {
+			right = append(right, Item[K, V]{k, value})
+		}
+	}
+	return fromSorted(left), fromSorted(right)
+}
+
+// Join returns a new SortedMap holding all the key-value items of the a
+// and b trees, providing every key in a is less than every key in b;
+// otherwise it returns nil and [ErrOverlap]. For example:
+//
+//	tree, err := Join(lower, upper)
+//
+// Neither a nor b is changed, so their items must be copied, making
+// this O(n+m), although the new tree is built directly without any
+// comparisons or rebalancing. To join two trees in O(log n + log m)
+// when b is no longer needed, use [SortedMap.Append], which moves b’s
+// nodes into a rather than copying them.
+// See also [SortedMap.Split]
+func Join[K Ordered, V any](a, b *SortedMap[K, V]) (*SortedMap[K, V],
+	error,
+) {
+	if a.root != nil && b.root != nil &&
+		!(last(a.root).key < first(b.root).key) {
+		return nil, ErrOverlap
+	}
+	items := make([]Item[K, V], 0, a.size+b.size)
+	for key, value := range a.All() {
+		items = append(items, Item[K, V]{key, value})
+	}
+	for key, value := range b.All() {
+		items = append(items, Item[K, V]{key, value})
+	}
+	return fromSorted(items), nil
+}
+
+// Append moves all of other’s key-value items into this tree, leaving
+// other empty, and returns nil, providing every key in this tree is less
+// than every key in other; otherwise it changes neither tree and returns
+// [ErrOverlap]. For example:
+//
+//	if err := log.Append(batch); err != nil { … }
+//
+// Rather than inserting other’s items one by one, Append joins the two
+// trees using other’s smallest item as a pivot, attaching it at the point
+// on the taller tree’s spine where the trees’ black heights match and
+// rebalancing from there up. So it is O(log n + log m) (unless either
+// tree has hooks, when it is O(m) since OnInsert or OnDelete is called
+// for each moved item, or shares nodes with a version made by
+// [WithInsert] or [WithDelete], when they must first be copied).
+// See also [Join] which leaves both trees unchanged.
+func (me *SortedMap[K, V]) Append(other *SortedMap[K, V]) error {
+	if other.root == nil {
 		return nil
 	}
-	if !isRed(root.left) && !isRed(root.left.left) {
-		root = moveRedLeft(root)
+	if me.root != nil && !(last(me.root).key < first(other.root).key) {
+		return ErrOverlap
 	}
-	root.left = deleteMinimum(root.left)
-	return fixUp(root)
+	var moved []Item[K, V]
+	if (me.hooks != nil && me.hooks.OnInsert != nil) ||
+		other.hooks.watchesDeletes() {
+		moved = make([]Item[K, V], 0, other.size)
+		for key, value := range other.All() {
+			moved = append(moved, Item[K, V]{key, value})
+		}
+	}
+	me.own()
+	other.own()
+	pivot := first(other.root)
+	if !isRed(other.root.left) && !isRed(other.root.right) {
+		other.root.red = true
+	}
+	if other.root = deleteMinimum(other.root); other.root != nil {
+		other.root.red = false
+	}
+	*pivot = node[K, V]{key: pivot.key, value: pivot.value, size: 1}
+	me.root = join(me.root, pivot, other.root, blackHeight(me.root),
+		blackHeight(other.root))
+	me.root.red = false
+	me.size += other.size
+	other.root = nil
+	other.size = 0
+	for _, item := range moved {
+		other.hooks.deleted(item.Key, item.Value)
+	}
+	for _, item := range moved {
+		me.hooks.inserted(item.Key, item.Value)
+	}
+	return nil
 }
 
-func fixUp[K Comparable, V any](root *node[K, V]) *node[K, V] {
-	if isRed(root.right) {
-		root = rotateLeft(root)
+// join returns the root of a tree holding the a tree’s nodes, then the
+// pivot, then the b tree’s nodes, where aHeight and bHeight are the trees’
+// black heights (see blackHeight). It walks down the taller tree’s spine
+// (the right spine of a or the left spine of b) to a black node with the
+// other tree’s black height, replaces that node with the pivot as a red
+// node whose children are the node and the other tree (just as if the
+// pivot had been inserted there), and then rebalances on the way back up
+// as insert does.
+func join[K Ordered, V any](a, pivot, b *node[K, V], aHeight,
+	bHeight int,
+) *node[K, V] {
+	switch {
+	case aHeight > bHeight:
+		a.right = join(a.right, pivot, b, aHeight-blackness(a), bHeight)
+		resize(a)
+		return insertRotation(a)
+	case aHeight < bHeight || isRed(b):
+		b.left = join(a, pivot, b.left, aHeight, bHeight-blackness(b))
+		resize(b)
+		return insertRotation(b)
+	default:
+		pivot.left, pivot.right, pivot.red = a, b, true
+		resize(pivot)
+		return pivot
 	}
-	if isRed(root.left) && isRed(root.left.left) {
-		root = rotateRight(root)
+}
+
+// blackHeight returns the number of black nodes on every path from the
+// root to a leaf, including the root itself if it is black.
+func blackHeight[K Ordered, V any](root *node[K, V]) int {
+	height := 0
+	for ; root != nil; root = root.left {
+		height += blackness(root)
 	}
-	if isRed(root.left) && isRed(root.right) {
-		colorFlip(root)
+	return height
+}
+
+// blackness returns 1 if the root is black or 0 if it is red, i.e., how
+// much less its children’s black height is than its own.
+func blackness[K Ordered, V any](root *node[K, V]) int {
+	if isRed(root) {
+		return 0
 	}
-	return root
+	return 1
 }
 
-// Clear deletes all the tree’s key-value items.
-// See also [Delete]
-func (me *SortedMap[K, V]) Clear() {
-	me.root = nil
-	me.size = 0
+// FindNearest returns the key and value of the item whose key is closest
+// to the given key (which need not be present), and true; or K’s and V’s
+// zero values and false if the tree is empty. Closeness is the absolute
+// difference between the keys, and ties are broken in favor of the
+// smaller key. For example, to snap a reading to the nearest stored
+// sample:
+//
+//	key, value, ok := FindNearest(&samples, reading)
+//
+// FindNearest is only for integer and float keys, since for other key
+// types (e.g., strings) there is no natural measure of distance. It
+// combines finding the floor (largest key <= key) and ceiling (smallest
+// key >= key), and so is O(log n).
+// See also [NearestN]
+func FindNearest[K Integer | Number, V any](tree *SortedMap[K, V],
+	key K,
+) (K, V, bool) {
+	floor, ceiling := tree.floorNode(key), tree.ceilingNode(key)
+	nearest := floor
+	if floor == nil || (ceiling != nil &&
+		isNearerAbove(key, floor.key, ceiling.key)) {
+		nearest = ceiling
+	}
+	if nearest == nil {
+		var zero V
+		return 0, zero, false
+	}
+	return nearest.key, nearest.value, true
+}
+
+// isNearerAbove returns true if above is strictly nearer to key than
+// below is, where below <= key <= above. Subtracting keys of opposite
+// signs can overflow (e.g., for int8 keys -100 and 100), so the distances
+// are only compared directly when below and above have the same sign.
+func isNearerAbove[K Integer | Number](key, below, above K) bool {
+	var zero K
+	switch {
+	case below >= zero || above < zero:
+		return above-key < key-below
+	case key >= zero: // above-key can't overflow but key-below might
+		return below < key-(above-key)
+	default: // key-below can't overflow but above-key might
+		return above < key+(key-below)
+	}
+}
+
+// NearestN returns (up to) the n key-value items whose keys are closest
+// to the given key (which need not be present), ordered by distance and
+// then by key, i.e., with ties broken in favor of the smaller key. If
+// the tree has fewer than n items, all of them are returned. For
+// example, to find the five readings nearest to a value:
+//
+//	items := NearestN(&readings, value, 5)
+//
+// NearestN walks outward from the given key in both directions at once,
+// and so is O(log n + n).
+// See also [FindNearest]
+func NearestN[K Number, V any](tree *SortedMap[K, V], key K,
+	n int,
+) []Item[K, V] {
+	items := make([]Item[K, V], 0, min(max(0, n), tree.size))
+	var lower reverseOrder[K, V]
+	var upper inOrder[K, V]
+	lower.pushBefore(tree.root, key)
+	upper.pushFrom(tree.root, key)
+	below, above := lower.prev(), upper.next()
+	for len(items) < cap(items) {
+		if below == nil || (above != nil &&
+			isNearerAbove(key, below.key, above.key)) {
+			items = append(items, Item[K, V]{above.key, above.value})
+			above = upper.next()
+		} else {
+			items = append(items, Item[K, V]{below.key, below.value})
+			below = lower.prev()
+		}
+	}
+	return items
+}
+
+// Gaps is a range function for use as an iterable in a for … range loop
+// that returns each pair of adjacent keys that have missing keys between
+// them, in key order. For example, if the keys are 1, 2, 5, 6, and 9:
+//
+//	for after, before := range Gaps(&received)
+//
+// returns 2, 5 and then 6, 9 (so 3 and 4, and 7 and 8, are missing).
+// This takes a single in-order walk.
+func Gaps[K Integer, V any](tree *SortedMap[K, V]) iter.Seq2[K, K] {
+	return func(yield func(K, K) bool) {
+		var stack inOrder[K, V]
+		stack.pushLeft(tree.root)
+		previous := stack.next()
+		if previous == nil {
+			return
+		}
+		for root := stack.next(); root != nil; root = stack.next() {
+			// previous.key+1 can’t overflow since root.key is larger
+			if root.key != previous.key+1 &&
+				!yield(previous.key, root.key) {
+				return
+			}
+			previous = root
+		}
+	}
+}
+
+// DeleteAndShift deletes the item with the given key and then subtracts
+// one from every larger key, and returns true; or does nothing and
+// returns false if the key isn’t in the tree. This is like deleting an
+// element from a slice: for example, if the keys are 0, 1, 2, 3, 4, then
+// after
+//
+//	ok := DeleteAndShift(&slots, 2)
+//
+// they are 0, 1, 2, 3 (with the values that were at 3 and 4 now at 2 and
+// 3). Since the relative order of the keys is unchanged, the keys are
+// adjusted in place without restructuring the tree, but every larger key
+// must be visited so this is O(n).
+func DeleteAndShift[K Integer, V any](tree *SortedMap[K, V], key K) bool {
+	if !tree.Delete(key) {
+		return false
+	}
+	var stack inOrder[K, V]
+	stack.pushAfter(tree.root, key)
+	for root := stack.next(); root != nil; root = stack.next() {
+		root.key--
+	}
+	return true
 }
@@ -2,11 +2,18 @@
 package sortedmap
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"weak"
 )
 
 func TestAPI(t *testing.T) {
@@ -286,6 +293,28 @@ func BenchmarkBTreeInsertion(b *testing.B) {
 	}
 }
 
+// Compare allocations with: go test -bench Fill -benchmem
+func BenchmarkBTreeFill(b *testing.B) {
+	var m SortedMap[int, int]
+	for range b.N {
+		for i := range 10000 {
+			m.Insert(i, i)
+		}
+		m.Clear()
+	}
+}
+
+func BenchmarkBTreeFillReserved(b *testing.B) {
+	var m SortedMap[int, int]
+	m.Reserve(10000)
+	for range b.N {
+		for i := range 10000 {
+			m.Insert(i, i)
+		}
+		m.Clear()
+	}
+}
+
 func BenchmarkBTreeIteration(b *testing.B) {
 	b.StopTimer() // Don't time creation and population
 	var m SortedMap[int, int]
@@ -303,6 +332,77 @@ func BenchmarkBTreeIteration(b *testing.B) {
 	}
 }
 
+func BenchmarkCountRange(b *testing.B) {
+	var m SortedMap[int, int]
+	for i := range 1000000 {
+		m.Insert(i, i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		lo := i % 500000
+		if m.Count(lo, lo+250000) != 250000 {
+			b.Fatal("wrong count")
+		}
+	}
+}
+
+func BenchmarkCountRangeNaive(b *testing.B) {
+	var m SortedMap[int, int]
+	for i := range 1000000 {
+		m.Insert(i, i)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		lo := i % 500000
+		count := 0
+		for range m.Range(lo, lo+250000) {
+			count++
+		}
+		if count != 250000 {
+			b.Fatal("wrong count")
+		}
+	}
+}
+
+func BenchmarkFindChurned(b *testing.B) {
+	m := churnedTree(1000000)
+	keys := m.KeysSlice()
+	b.ResetTimer()
+	for i := range b.N {
+		m.Find(keys[(i*7919)%len(keys)])
+	}
+}
+
+func BenchmarkFindRebuilt(b *testing.B) {
+	m := churnedTree(1000000)
+	m.Rebuild()
+	keys := m.KeysSlice()
+	b.ResetTimer()
+	for i := range b.N {
+		m.Find(keys[(i*7919)%len(keys)])
+	}
+}
+
+func BenchmarkTruncateTo(b *testing.B) {
+	var m SortedMap[int, int]
+	for i := range b.N {
+		for j := range 1000 {
+			m.Insert((i*7919+j*104729)%1000000, j)
+		}
+		m.TruncateTo(100)
+	}
+}
+
+func BenchmarkTruncateToKeepSmallest(b *testing.B) {
+	var m SortedMap[int, int]
+	for i := range b.N {
+		for j := range 1000 {
+			m.Insert((i*7919+j*104729)%1000000, j)
+		}
+		m.KeepSmallest(100)
+	}
+}
+
 func Test_DeleteValue(t *testing.T) {
 	var tree SortedMap[int, string]
 	var tree2 SortedMap[int, string]
@@ -364,3 +464,2634 @@ func Test_DeleteValue(t *testing.T) {
 		}
 	}
 }
+
+func TestMapValues(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range []string{"delta", "alpha", "echo", "charlie",
+		"bravo"} {
+		tree.Insert(word, i)
+	}
+	var order []string
+	lengths := MapValues(&tree, func(key string, value int) string {
+		order = append(order, key)
+		return fmt.Sprintf("%s=%d", key, value)
+	})
+	if lengths.Len() != tree.Len() {
+		t.Errorf("expected %d; got %d", tree.Len(), lengths.Len())
+	}
+	actual := strings.Join(slices.Collect(lengths.Values()), " ")
+	expected := "alpha=1 bravo=4 charlie=3 delta=0 echo=2"
+	if actual != expected {
+		t.Errorf("expected %q; got %q", expected, actual)
+	}
+	if !slices.Equal(order, slices.Collect(tree.Keys())) {
+		t.Errorf("expected fn called in key order; got %v", order)
+	}
+	lengths.Insert("foxtrot", "foxtrot=5")
+	lengths.Delete("alpha")
+	if tree.Len() != 5 || !tree.Contains("alpha") {
+		t.Error("expected original tree to be unchanged")
+	}
+}
+
+func TestHeight(t *testing.T) {
+	var tree SortedMap[int, int]
+	if tree.Height() != 0 {
+		t.Errorf("expected 0; got %d", tree.Height())
+	}
+	tree.Insert(1, 1)
+	if tree.Height() != 1 {
+		t.Errorf("expected 1; got %d", tree.Height())
+	}
+	for i := range 100000 { // sorted input is the worst case for a BST
+		tree.Insert(i, i)
+	}
+	limit := int(2 * math.Log2(float64(tree.Len()+1)))
+	if height := tree.Height(); height > limit {
+		t.Errorf("expected height <= %d; got %d", limit, height)
+	}
+	for i := range 90000 {
+		tree.Delete(i)
+	}
+	limit = int(2 * math.Log2(float64(tree.Len()+1)))
+	if height := tree.Height(); height > limit {
+		t.Errorf("expected height <= %d; got %d", limit, height)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	var tree SortedMap[int, int]
+	if err := tree.CheckInvariants(); err != nil {
+		t.Errorf("expected valid empty tree; got %v", err)
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 20000 {
+		key := rng.IntN(2000)
+		if rng.IntN(3) == 0 {
+			tree.Delete(key)
+		} else {
+			tree.Insert(key, i)
+		}
+		if i%97 == 0 {
+			if err := tree.CheckInvariants(); err != nil {
+				t.Fatalf("operation #%d: %v", i, err)
+			}
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	tree.root.red = true
+	if err := tree.CheckInvariants(); err == nil {
+		t.Error("expected red root to be invalid")
+	}
+	tree.root.red = false
+	tree.root.key, tree.root.left.key = tree.root.left.key, tree.root.key
+	if err := tree.CheckInvariants(); err == nil {
+		t.Error("expected misordered keys to be invalid")
+	}
+	tree.root.key, tree.root.left.key = tree.root.left.key, tree.root.key
+	tree.size++
+	if err := tree.CheckInvariants(); err == nil {
+		t.Error("expected wrong size to be invalid")
+	}
+}
+
+func TestAllFrom(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 20 {
+		tree.Insert(i*5, strconv.Itoa(i*5))
+	}
+	var keys []int
+	for key, value := range tree.AllFrom(42) {
+		if value != strconv.Itoa(key) {
+			t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+		}
+		keys = append(keys, key)
+	}
+	expected := []int{45, 50, 55, 60, 65, 70, 75, 80, 85, 90, 95}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	keys = keys[:0]
+	for key := range tree.AllFrom(45) {
+		keys = append(keys, key)
+		if len(keys) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(keys, []int{45, 50, 55}) {
+		t.Errorf("expected [45 50 55]; got %v", keys)
+	}
+	for key := range tree.AllFrom(96) {
+		t.Errorf("expected no keys; got %d", key)
+	}
+	keys = keys[:0]
+	for key := range tree.AllFrom(-1) {
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, slices.Collect(tree.Keys())) {
+		t.Errorf("expected all keys; got %v", keys)
+	}
+}
+
+func TestInsertMany(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("one", -1)
+	tree.InsertMany(maps.All(map[string]int{"one": 1, "two": 2,
+		"three": 3}))
+	if tree.Len() != 3 {
+		t.Errorf("expected 3; got %d", tree.Len())
+	}
+	if value, ok := tree.Find("one"); !ok || value != 1 {
+		t.Errorf("expected 1, true; got %d, %t", value, ok)
+	}
+	var other SortedMap[string, int]
+	other.InsertMany(tree.All())
+	if !other.Equal(tree) {
+		t.Error("expected equal trees")
+	}
+}
+
+func TestBulkLoadSorted(t *testing.T) {
+	for size := range 300 {
+		items := make([]Item[int, int], 0, size)
+		for i := range size {
+			items = append(items, Item[int, int]{i * 2, i})
+		}
+		var tree SortedMap[int, int]
+		tree.BulkLoadSorted(items)
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if tree.Len() != size {
+			t.Errorf("expected %d; got %d", size, tree.Len())
+		}
+		i := 0
+		for key, value := range tree.All() {
+			if key != i*2 || value != i {
+				t.Errorf("expected %d:%d; got %d:%d", i*2, i, key, value)
+			}
+			i++
+		}
+		for key := range size * 2 {
+			tree.Delete(key)
+			if err := tree.CheckInvariants(); err != nil {
+				t.Fatalf("size %d delete %d: %v", size, key, err)
+			}
+		}
+	}
+	// unsorted (or nonempty) falls back to insertion
+	var tree SortedMap[int, string]
+	tree.BulkLoadSorted([]Item[int, string]{{3, "c"}, {1, "a"}, {3, "C"},
+		{2, "b"}})
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	actual := strings.Join(slices.Collect(tree.Values()), "")
+	if actual != "abC" {
+		t.Errorf("expected %q; got %q", "abC", actual)
+	}
+	tree.BulkLoadSorted([]Item[int, string]{{0, "z"}, {4, "d"}})
+	actual = strings.Join(slices.Collect(tree.Values()), "")
+	if actual != "zabCd" {
+		t.Errorf("expected %q; got %q", "zabCd", actual)
+	}
+}
+
+func TestKeysValuesSlice(t *testing.T) {
+	var tree SortedMap[string, int]
+	if keys := tree.KeysSlice(); keys == nil || len(keys) != 0 {
+		t.Errorf("expected empty slice; got %v", keys)
+	}
+	for i, word := range strings.Fields("can in a ebony go be dent for") {
+		tree.Insert(word, i)
+	}
+	keys := tree.KeysSlice()
+	expected := []string{"a", "be", "can", "dent", "ebony", "for", "go",
+		"in"}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if cap(keys) != tree.Len() {
+		t.Errorf("expected cap %d; got %d", tree.Len(), cap(keys))
+	}
+	values := tree.ValuesSlice()
+	if !slices.Equal(values, []int{2, 5, 0, 6, 3, 7, 4, 1}) {
+		t.Errorf("expected [2 5 0 6 3 7 4 1]; got %v", values)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("a", 1)
+	tree.Insert("b", 0)
+	total := tree.GetOr("a", 10) + tree.GetOr("b", 20) + tree.GetOr("c", 30)
+	if total != 31 {
+		t.Errorf("expected 31; got %d", total)
+	}
+}
+
+func TestDifference(t *testing.T) {
+	var a, b SortedMap[int, string]
+	for i := range 20 {
+		a.Insert(i, strconv.Itoa(i))
+	}
+	for i := 0; i < 30; i += 3 {
+		b.Insert(i, "")
+	}
+	b.Insert(-1, "")
+	difference := a.Difference(&b)
+	if err := difference.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	expected := []int{1, 2, 4, 5, 7, 8, 10, 11, 13, 14, 16, 17, 19}
+	if keys := difference.KeysSlice(); !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if value, _ := difference.Find(13); value != "13" {
+		t.Errorf("expected %q; got %q", "13", value)
+	}
+	difference.Insert(3, "three")
+	difference.Delete(1)
+	if a.Len() != 20 || b.Len() != 11 {
+		t.Errorf("expected inputs unchanged; got %d %d", a.Len(), b.Len())
+	}
+	if value, _ := a.Find(3); value != "3" {
+		t.Errorf("expected %q; got %q", "3", value)
+	}
+	var empty SortedMap[int, string]
+	if difference := empty.Difference(&a); difference.Len() != 0 {
+		t.Errorf("expected 0; got %d", difference.Len())
+	}
+	if difference := a.Difference(&empty); !difference.Equal(a) {
+		t.Error("expected equal trees")
+	}
+}
+
+func TestUnion(t *testing.T) {
+	var a, b SortedMap[string, int]
+	for i, word := range strings.Fields("one two three four") {
+		a.Insert(word, i+1)
+	}
+	for i, word := range strings.Fields("three four five six") {
+		b.Insert(word, (i+3)*10)
+	}
+	union := a.Union(&b, func(x, y int) int { return x + y })
+	if err := union.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	var out strings.Builder
+	for key, value := range union.All() {
+		out.WriteString(fmt.Sprintf("%s:%d ", key, value))
+	}
+	actual := strings.TrimSpace(out.String())
+	expected := "five:50 four:44 one:1 six:60 three:33 two:2"
+	if actual != expected {
+		t.Errorf("expected %q; got %q", expected, actual)
+	}
+	union = a.Union(&b, nil)
+	if value, _ := union.Find("three"); value != 30 {
+		t.Errorf("expected 30; got %d", value)
+	}
+	union.Insert("seven", 7)
+	if a.Len() != 4 || b.Len() != 4 || a.Contains("five") {
+		t.Error("expected inputs to be unchanged")
+	}
+	var empty SortedMap[string, int]
+	if union := empty.Union(&empty, nil); union.Len() != 0 {
+		t.Errorf("expected 0; got %d", union.Len())
+	}
+	if union := empty.Union(&a, nil); !union.Equal(a) {
+		t.Error("expected equal trees")
+	}
+}
+
+func TestPage(t *testing.T) {
+	var tree SortedMap[int, int]
+	items, after, more := tree.FirstPage(5)
+	if len(items) != 0 || after != 0 || more {
+		t.Errorf("expected no items; got %v %d %t", items, after, more)
+	}
+	for i := range 23 {
+		tree.Insert(i*2, i)
+	}
+	var keys []int
+	pages := 0
+	items, after, more = tree.FirstPage(5)
+	for {
+		pages++
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+		if len(items) > 0 && after != items[len(items)-1].Key {
+			t.Errorf("expected after %d; got %d",
+				items[len(items)-1].Key, after)
+		}
+		if !more {
+			break
+		}
+		items, after, more = tree.Page(after, 5)
+	}
+	if pages != 5 {
+		t.Errorf("expected 5 pages; got %d", pages)
+	}
+	if !slices.Equal(keys, tree.KeysSlice()) {
+		t.Errorf("expected all keys; got %v", keys)
+	}
+	items, after, more = tree.Page(7, 3)
+	if len(items) != 3 || items[0].Key != 8 || after != 12 || !more {
+		t.Errorf("expected 8..12 and more; got %v %d %t", items, after,
+			more)
+	}
+	items, after, more = tree.Page(40, 3)
+	if len(items) != 2 || after != 44 || more {
+		t.Errorf("expected 42 44 and no more; got %v %d %t", items, after,
+			more)
+	}
+	items, after, more = tree.Page(44, 3)
+	if len(items) != 0 || after != 44 || more {
+		t.Errorf("expected no items; got %v %d %t", items, after, more)
+	}
+	items, after, more = tree.Page(-1, 0)
+	if len(items) != 0 || after != -1 || !more {
+		t.Errorf("expected no items and more; got %v %d %t", items, after,
+			more)
+	}
+}
+
+func TestRankCounts(t *testing.T) {
+	var tree SortedMap[int, int]
+	if tree.Rank(5) != 0 || tree.CountLess(5) != 0 ||
+		tree.CountGreater(5) != 0 {
+		t.Error("expected 0 counts for empty tree")
+	}
+	for i := range 50 {
+		tree.Insert(i*10, i)
+	}
+	for _, datum := range []struct{ key, less, greater int }{
+		{-5, 0, 50}, {0, 0, 49}, {5, 1, 49}, {10, 1, 48}, {245, 25, 25},
+		{250, 25, 24}, {490, 49, 0}, {495, 50, 0},
+	} {
+		if less := tree.CountLess(datum.key); less != datum.less {
+			t.Errorf("CountLess(%d): expected %d; got %d", datum.key,
+				datum.less, less)
+		}
+		if rank := tree.Rank(datum.key); rank != datum.less {
+			t.Errorf("Rank(%d): expected %d; got %d", datum.key,
+				datum.less, rank)
+		}
+		if greater := tree.CountGreater(datum.key); greater !=
+			datum.greater {
+			t.Errorf("CountGreater(%d): expected %d; got %d", datum.key,
+				datum.greater, greater)
+		}
+	}
+	for i := 0; i < 50; i += 2 {
+		tree.Delete(i * 10)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if less := tree.CountLess(250); less != 12 {
+		t.Errorf("expected 12; got %d", less)
+	}
+	if greater := tree.CountGreater(250); greater != 12 {
+		t.Errorf("expected 12; got %d", greater)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var tree SortedMap[int, string]
+	if _, _, ok := tree.Select(0); ok {
+		t.Error("expected false; got true")
+	}
+	for i := range 100 {
+		tree.Insert(i*3, strconv.Itoa(i))
+	}
+	for i := range 100 {
+		key, value, ok := tree.Select(i)
+		if !ok || key != i*3 || value != strconv.Itoa(i) {
+			t.Errorf("expected %d %d true; got %d %s %t", i*3, i, key,
+				value, ok)
+		}
+		if rank := tree.Rank(key); rank != i {
+			t.Errorf("expected rank %d; got %d", i, rank)
+		}
+	}
+	for _, i := range []int{-1, 100, 1000} {
+		if _, _, ok := tree.Select(i); ok {
+			t.Errorf("expected Select(%d) to fail", i)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var tree SortedMap[int, int]
+	if _, _, ok := tree.Percentile(0.5); ok {
+		t.Error("expected false; got true")
+	}
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i*5, i)
+	}
+	for _, datum := range []struct {
+		p   float64
+		key int
+	}{
+		{-1, 5}, {0, 5}, {0.05, 5}, {0.06, 10}, {0.5, 50}, {0.95, 95},
+		{0.951, 100}, {1, 100}, {7, 100}, {math.NaN(), 5},
+	} {
+		key, value, ok := tree.Percentile(datum.p)
+		if !ok || key != datum.key || value*5 != key {
+			t.Errorf("Percentile(%g): expected %d; got %d %d %t", datum.p,
+				datum.key, key, value, ok)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 10 {
+		tree.Insert(i, i*i)
+	}
+	total := 0
+	if err := tree.Walk(func(key, value int) error {
+		total += value
+		return nil
+	}); err != nil || total != 285 {
+		t.Errorf("expected 285 <nil>; got %d %v", total, err)
+	}
+	errStop := errors.New("stop")
+	var keys []int
+	err := tree.Walk(func(key, value int) error {
+		if key == 4 {
+			return errStop
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected %v; got %v", errStop, err)
+	}
+	if !slices.Equal(keys, []int{0, 1, 2, 3}) {
+		t.Errorf("expected [0 1 2 3]; got %v", keys)
+	}
+}
+
+func TestLevelOrder(t *testing.T) {
+	var tree SortedMap[int, string]
+	for range tree.LevelOrder() {
+		t.Error("expected no nodes")
+	}
+	items := make([]Item[int, string], 0, 7)
+	for i := range 7 {
+		items = append(items, Item[int, string]{i, strconv.Itoa(i)})
+	}
+	tree.BulkLoadSorted(items) // perfectly balanced
+	var out strings.Builder
+	for depth, item := range tree.LevelOrder() {
+		out.WriteString(fmt.Sprintf("%d:%d=%s ", depth, item.Key,
+			item.Value))
+	}
+	actual := strings.TrimSpace(out.String())
+	expected := "0:3=3 1:1=1 1:5=5 2:0=0 2:2=2 2:4=4 2:6=6"
+	if actual != expected {
+		t.Errorf("expected %q; got %q", expected, actual)
+	}
+	for i := 7; i < 100; i++ {
+		tree.Insert(i, strconv.Itoa(i))
+	}
+	count, deepest := 0, 0
+	for depth := range tree.LevelOrder() {
+		if depth < deepest {
+			t.Errorf("expected depth >= %d; got %d", deepest, depth)
+		}
+		deepest = depth
+		count++
+	}
+	if count != tree.Len() || deepest != tree.Height()-1 {
+		t.Errorf("expected %d nodes %d deep; got %d %d", tree.Len(),
+			tree.Height()-1, count, deepest)
+	}
+	for depth := range tree.LevelOrder() {
+		if depth > 0 {
+			break
+		}
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	var squares SortedMap[int, int]
+	squares.Insert(3, -1)
+	squares.CopyFrom(func(yield func(int, int) bool) {
+		for i := range 10 {
+			if !yield(i, i*i) {
+				return
+			}
+		}
+	})
+	if squares.Len() != 10 {
+		t.Errorf("expected 10; got %d", squares.Len())
+	}
+	if value, _ := squares.Find(3); value != 9 {
+		t.Errorf("expected 9; got %d", value)
+	}
+	var other SortedMap[int, int]
+	other.CopyFrom(squares.All())
+	if !other.Equal(squares) || other.Len() != 10 {
+		t.Error("expected equal trees")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	var tree SortedMap[int, int]
+	tree.Reserve(1000)
+	allocs := testing.AllocsPerRun(10, func() {
+		for i := range 1000 {
+			tree.Insert(i, i)
+		}
+		tree.Clear()
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocations; got %g", allocs)
+	}
+	for i := range 1500 { // more than reserved
+		tree.Insert(i, -i)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	for key, value := range tree.All() {
+		if value != -key {
+			t.Errorf("expected %d; got %d", -key, value)
+		}
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 1000 {
+		tree.Insert(i, i%7)
+	}
+	count := tree.DeleteFunc(func(key, value int) bool {
+		return value == 0 || key >= 900
+	})
+	if count != 229 {
+		t.Errorf("expected 229; got %d", count)
+	}
+	if tree.Len() != 771 {
+		t.Errorf("expected 771; got %d", tree.Len())
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	previous := -1
+	for key, value := range tree.All() {
+		if value == 0 || key >= 900 || key <= previous {
+			t.Errorf("unexpected %d:%d after %d", key, value, previous)
+		}
+		previous = key
+	}
+	if count := tree.DeleteFunc(func(int, int) bool {
+		return false
+	}); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+}
+
+func TestRange(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 50 {
+		tree.Insert(i*2, strconv.Itoa(i*2))
+	}
+	var keys []int
+	for key, value := range tree.Range(9, 21) {
+		if value != strconv.Itoa(key) {
+			t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+		}
+		keys = append(keys, key)
+	}
+	expected := []int{10, 12, 14, 16, 18, 20}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if keys := slices.Collect(tree.RangeKeys(10, 20)); !slices.Equal(keys,
+		expected[:5]) {
+		t.Errorf("expected %v; got %v", expected[:5], keys)
+	}
+	values := slices.Collect(tree.RangeValues(-10, 5))
+	if !slices.Equal(values, []string{"0", "2", "4"}) {
+		t.Errorf("expected [0 2 4]; got %v", values)
+	}
+	for _, bounds := range [][2]int{{20, 10}, {10, 10}, {11, 12},
+		{100, 200}} {
+		for key := range tree.RangeKeys(bounds[0], bounds[1]) {
+			t.Errorf("expected nothing in [%d, %d); got %d", bounds[0],
+				bounds[1], key)
+		}
+	}
+	keys = keys[:0]
+	for key := range tree.Range(0, 100) {
+		keys = append(keys, key)
+		if len(keys) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(keys, []int{0, 2, 4}) {
+		t.Errorf("expected [0 2 4]; got %v", keys)
+	}
+	for value := range tree.RangeValues(0, 100) {
+		if value == "4" {
+			break
+		}
+	}
+	for key := range tree.RangeKeys(0, 100) {
+		if key == 4 {
+			break
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 1000 {
+		tree.Insert(i, i)
+	}
+	if count := tree.Trim(250, 750); count != 500 {
+		t.Errorf("expected 500; got %d", count)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	minimum, _, _ := tree.Select(0)
+	maximum, _, _ := tree.Select(tree.Len() - 1)
+	if tree.Len() != 500 || minimum != 250 || maximum != 749 {
+		t.Errorf("expected 500 from 250 to 749; got %d from %d to %d",
+			tree.Len(), minimum, maximum)
+	}
+	if count := tree.Trim(0, 10000); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	if count := tree.Trim(300, 300); count != 500 || tree.Len() != 0 {
+		t.Errorf("expected 500 and empty; got %d %d", count, tree.Len())
+	}
+	if count := tree.Trim(0, 1); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+}
+
+func TestAnyEvery(t *testing.T) {
+	var tree SortedMap[int, int]
+	isNegative := func(_, value int) bool { return value < 0 }
+	if tree.Any(isNegative) {
+		t.Error("expected Any to be false for an empty tree")
+	}
+	if !tree.Every(isNegative) {
+		t.Error("expected Every to be true for an empty tree")
+	}
+	for i := range 10 {
+		tree.Insert(i, i-3)
+	}
+	calls := 0
+	if !tree.Any(func(_, value int) bool {
+		calls++
+		return value < 0
+	}) || calls != 1 {
+		t.Errorf("expected Any to stop after 1 call; got %d", calls)
+	}
+	calls = 0
+	if tree.Every(func(_, value int) bool {
+		calls++
+		return value < 0
+	}) || calls != 4 {
+		t.Errorf("expected Every to stop after 4 calls; got %d", calls)
+	}
+	if !tree.Every(func(key, value int) bool { return key-value == 3 }) {
+		t.Error("expected Every to be true")
+	}
+	if tree.Any(func(_, value int) bool { return value > 6 }) {
+		t.Error("expected Any to be false")
+	}
+}
+
+func TestReduce(t *testing.T) {
+	var tree SortedMap[string, int]
+	join := func(acc string, key string, value int) string {
+		return fmt.Sprintf("%s%s%d", acc, key, value)
+	}
+	if text := Reduce(&tree, "init", join); text != "init" {
+		t.Errorf("expected %q; got %q", "init", text)
+	}
+	for i, word := range strings.Fields("c a d b") {
+		tree.Insert(word, i)
+	}
+	if text := Reduce(&tree, ">", join); text != ">a1b3c0d2" {
+		t.Errorf("expected %q; got %q", ">a1b3c0d2", text)
+	}
+	sum := Reduce(&tree, 0.5, func(acc float64, _ string, value int) float64 {
+		return acc + float64(value)
+	})
+	if sum != 6.5 {
+		t.Errorf("expected 6.5; got %g", sum)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i*2, i)
+	}
+	for _, datum := range []struct{ key, left int }{
+		{-1, 0}, {0, 0}, {1, 1}, {50, 25}, {51, 26}, {198, 99}, {500, 100},
+	} {
+		left, right := tree.Split(datum.key)
+		if left.Len() != datum.left || left.Len()+right.Len() != 100 {
+			t.Errorf("Split(%d): expected %d + %d; got %d + %d", datum.key,
+				datum.left, 100-datum.left, left.Len(), right.Len())
+		}
+		for _, part := range []*SortedMap[int, int]{left, right} {
+			if err := part.CheckInvariants(); err != nil {
+				t.Error(err)
+			}
+		}
+		if !left.Every(func(key, _ int) bool { return key < datum.key }) ||
+			!right.Every(func(key, _ int) bool { return key >= datum.key }) {
+			t.Errorf("Split(%d): keys on the wrong side", datum.key)
+		}
+	}
+	left, _ := tree.Split(100)
+	left.Delete(0)
+	if tree.Len() != 100 || !tree.Contains(0) {
+		t.Error("expected original tree to be unchanged")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i*i)
+	}
+	left, right := tree.Split(37)
+	joined, err := Join(left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := joined.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if !slices.Equal(joined.ValuesSlice(), tree.ValuesSlice()) {
+		t.Error("expected joined tree to equal original")
+	}
+	if joined, err := Join(right, left); err != ErrOverlap ||
+		joined != nil {
+		t.Errorf("expected nil and ErrOverlap; got %v %v", joined, err)
+	}
+	var empty SortedMap[int, int]
+	for _, pair := range [][2]*SortedMap[int, int]{{&empty, left},
+		{left, &empty}, {&empty, &empty}} {
+		joined, err := Join(pair[0], pair[1])
+		if err != nil || joined.Len() != pair[0].Len()+pair[1].Len() {
+			t.Errorf("expected %d items; got %d %v",
+				pair[0].Len()+pair[1].Len(), joined.Len(), err)
+		}
+	}
+	left.Insert(37, 0) // equal to right's first key
+	if _, err := Join(left, right); err != ErrOverlap {
+		t.Errorf("expected ErrOverlap; got %v", err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(5, 6))
+	for _, sizes := range [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 1},
+		{1, 2}, {1000, 1}, {1, 1000}, {1000, 3}, {3, 1000}, {500, 700},
+		{4095, 4096}, {100000, 10}, {10, 100000},
+	} {
+		var a, b SortedMap[int, int]
+		for i := range sizes[0] {
+			a.Insert(i, -i)
+		}
+		for i := range sizes[1] {
+			b.Insert(sizes[0]+i, -sizes[0]-i)
+		}
+		for range rnd.IntN(sizes[0]/2 + 1) { // vary the trees’ shapes
+			a.Delete(rnd.IntN(sizes[0]))
+		}
+		size := a.Len() + b.Len()
+		if err := a.Append(&b); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.CheckInvariants(); err != nil {
+			t.Fatalf("%v: %s", sizes, err)
+		}
+		if a.Len() != size || b.Len() != 0 || b.root != nil {
+			t.Errorf("%v: expected %d and 0; got %d %d", sizes, size,
+				a.Len(), b.Len())
+		}
+		previous := -1
+		for key, value := range a.All() {
+			if key <= previous || value != -key {
+				t.Fatalf("%v: %d=%d out of order after %d", sizes, key,
+					value, previous)
+			}
+			previous = key
+		}
+	}
+	var a, b SortedMap[int, int]
+	a.Insert(5, 5)
+	b.Insert(5, 5)
+	b.Insert(6, 6)
+	if err := a.Append(&b); err != ErrOverlap || a.Len() != 1 ||
+		b.Len() != 2 {
+		t.Errorf("expected ErrOverlap and both unchanged; got %v", err)
+	}
+	if err := a.Append(&a); err != ErrOverlap {
+		t.Errorf("expected ErrOverlap; got %v", err)
+	}
+	var events []string
+	a.SetHooks(Hooks[int, int]{OnInsert: func(key, _ int) {
+		events = append(events, "+"+strconv.Itoa(key))
+	}})
+	b.Delete(5)
+	b.Insert(7, 7)
+	b.SetHooks(Hooks[int, int]{OnDelete: func(key, _ int) {
+		events = append(events, "-"+strconv.Itoa(key))
+	}})
+	if err := a.Append(&b); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(events, " "); got != "-6 -7 +6 +7" {
+		t.Errorf("expected -6 -7 +6 +7; got %s", got)
+	}
+	var lower, upper SortedMap[int, int]
+	for i := range 100 {
+		lower.Insert(i, i)
+		upper.Insert(100+i, i)
+	}
+	version := upper.WithInsert(200, 200)
+	if err := lower.Append(version); err != nil {
+		t.Fatal(err)
+	}
+	if upper.Len() != 100 || !upper.Contains(100) || lower.Len() != 201 {
+		t.Errorf("expected the shared version’s original to be unchanged")
+	}
+	if err := upper.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContainsAllAny(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("red green blue") {
+		tree.Insert(word, i)
+	}
+	for _, datum := range []struct {
+		keys     []string
+		all, any bool
+	}{
+		{nil, true, false},
+		{[]string{"red"}, true, true},
+		{[]string{"blue", "red", "green"}, true, true},
+		{[]string{"red", "pink"}, false, true},
+		{[]string{"pink", "blue"}, false, true},
+		{[]string{"pink", "cyan"}, false, false},
+	} {
+		if all := tree.ContainsAll(datum.keys...); all != datum.all {
+			t.Errorf("ContainsAll(%v): expected %t; got %t", datum.keys,
+				datum.all, all)
+		}
+		if any := tree.ContainsAny(datum.keys...); any != datum.any {
+			t.Errorf("ContainsAny(%v): expected %t; got %t", datum.keys,
+				datum.any, any)
+		}
+	}
+}
+
+func TestReplaceValue(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("a", 1)
+	if !tree.ReplaceValue("a", 10) {
+		t.Error("expected true; got false")
+	}
+	if tree.ReplaceValue("b", 20) {
+		t.Error("expected false; got true")
+	}
+	if value, _ := tree.Find("a"); value != 10 {
+		t.Errorf("expected 10; got %d", value)
+	}
+	if tree.Contains("b") || tree.Len() != 1 {
+		t.Error("expected b not to be inserted")
+	}
+}
+
+func TestInsertIfAbsent(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i, word := range strings.Fields("one two three four five six") {
+		ok := tree.InsertIfAbsent(len(word), word)
+		if expected := i != 1 && i != 4 && i != 5; ok != expected {
+			t.Errorf("%q: expected %t; got %t", word, expected, ok)
+		}
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	actual := strings.Join(tree.ValuesSlice(), " ")
+	if actual != "one four three" {
+		t.Errorf("expected %q; got %q", "one four three", actual)
+	}
+}
+
+func TestCount(t *testing.T) {
+	var tree SortedMap[int, int]
+	if count := tree.Count(0, 10); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	for i := range 100 {
+		tree.Insert(i*2, i)
+	}
+	for _, bounds := range [][2]int{{0, 200}, {-5, 5}, {9, 21}, {10, 20},
+		{11, 12}, {150, 1000}, {20, 10}, {10, 10}} {
+		expected := 0
+		for range tree.Range(bounds[0], bounds[1]) {
+			expected++
+		}
+		if count := tree.Count(bounds[0], bounds[1]); count != expected {
+			t.Errorf("Count(%d, %d): expected %d; got %d", bounds[0],
+				bounds[1], expected, count)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("c a d b") {
+		tree.Insert(word, i)
+	}
+	for i, expected := range []string{"a", "b", "c", "d"} {
+		if key, value := tree.At(i); key != expected ||
+			value != tree.GetOr(expected, -1) {
+			t.Errorf("At(%d): expected %q; got %q %d", i, expected, key,
+				value)
+		}
+	}
+	for _, index := range []int{-1, 4} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("At(%d): expected panic", index)
+				}
+			}()
+			tree.At(index)
+		}()
+	}
+}
+
+func TestKeyAtValueAt(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 10 {
+		tree.Insert(i*i, strconv.Itoa(i))
+	}
+	if key, ok := tree.KeyAt(3); !ok || key != 9 {
+		t.Errorf("expected 9 true; got %d %t", key, ok)
+	}
+	if value, ok := tree.ValueAt(7); !ok || value != "7" {
+		t.Errorf("expected 7 true; got %q %t", value, ok)
+	}
+	for _, index := range []int{-1, 10} {
+		if key, ok := tree.KeyAt(index); ok || key != 0 {
+			t.Errorf("KeyAt(%d): expected 0 false; got %d %t", index, key,
+				ok)
+		}
+		if value, ok := tree.ValueAt(index); ok || value != "" {
+			t.Errorf("ValueAt(%d): expected \"\" false; got %q %t", index,
+				value, ok)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	var tree SortedMap[int, int]
+	tree.Reserve(100)
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	tree.Clear()
+	if tree.Len() != 0 || len(tree.free) != 100 {
+		t.Errorf("expected Clear to keep 100 nodes; got %d", len(tree.free))
+	}
+	for i := range 50 {
+		tree.Insert(i, i)
+	}
+	if len(tree.free) != 50 {
+		t.Errorf("expected 50 nodes to be reused; got %d",
+			100-len(tree.free))
+	}
+	tree.Release()
+	if tree.Len() != 0 || tree.root != nil || tree.free != nil ||
+		tree.pool != nil {
+		t.Error("expected everything to be released")
+	}
+	for i := range 10 {
+		tree.Insert(i, i)
+	}
+	tree.Clear()
+	if len(tree.free) != 0 {
+		t.Errorf("expected Clear not to keep nodes; got %d", len(tree.free))
+	}
+}
+
+func TestDeleteMinMax(t *testing.T) {
+	var tree SortedMap[int, int]
+	if tree.DeleteMin() || tree.DeleteMax() {
+		t.Error("expected false for empty tree")
+	}
+	for i := range 200 {
+		tree.Insert(i, i)
+	}
+	for i := range 100 {
+		if !tree.DeleteMin() {
+			t.Error("expected true; got false")
+		}
+		if !tree.DeleteMax() {
+			t.Error("expected true; got false")
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+		if tree.Len() != 200-(i+1)*2 {
+			t.Errorf("expected %d; got %d", 200-(i+1)*2, tree.Len())
+		}
+		if tree.Len() > 0 {
+			if key, _ := tree.KeyAt(0); key != i+1 {
+				t.Errorf("expected min %d; got %d", i+1, key)
+			}
+			if key, _ := tree.KeyAt(tree.Len() - 1); key != 198-i {
+				t.Errorf("expected max %d; got %d", 198-i, key)
+			}
+		}
+	}
+	if tree.DeleteMin() || tree.DeleteMax() {
+		t.Error("expected false for emptied tree")
+	}
+}
+
+func TestFindNearest(t *testing.T) {
+	var tree SortedMap[uint, string]
+	if key, _, ok := FindNearest(&tree, 5); ok || key != 0 {
+		t.Errorf("expected false; got %d %t", key, ok)
+	}
+	for _, key := range []uint{10, 20, 30, 35, 1000} {
+		tree.Insert(key, strconv.Itoa(int(key)))
+	}
+	for _, datum := range []struct{ key, nearest uint }{
+		{0, 10}, {10, 10}, {14, 10}, {15, 10}, {16, 20}, {32, 30},
+		{33, 35}, {517, 35}, {518, 1000}, {5000, 1000},
+	} {
+		key, value, ok := FindNearest(&tree, datum.key)
+		if !ok || key != datum.nearest || value != strconv.Itoa(int(key)) {
+			t.Errorf("FindNearest(%d): expected %d; got %d %q %t",
+				datum.key, datum.nearest, key, value, ok)
+		}
+	}
+}
+
+func TestFindNearestFloat(t *testing.T) {
+	var tree SortedMap[float64, string]
+	if key, _, ok := FindNearest(&tree, 0.5); ok || key != 0 {
+		t.Errorf("expected false; got %g %t", key, ok)
+	}
+	for _, key := range []float64{-1.5, 0.25, 0.75, 1e300} {
+		tree.Insert(key, strconv.FormatFloat(key, 'g', -1, 64))
+	}
+	for _, datum := range []struct{ key, nearest float64 }{
+		{-10, -1.5}, {-0.625, -1.5}, {-0.6, 0.25}, {0.5, 0.25},
+		{0.6, 0.75}, {6e299, 1e300}, {-1e308, -1.5}, {math.Inf(1), 1e300},
+	} {
+		key, value, ok := FindNearest(&tree, datum.key)
+		if !ok || key != datum.nearest ||
+			value != strconv.FormatFloat(key, 'g', -1, 64) {
+			t.Errorf("FindNearest(%g): expected %g; got %g %q %t",
+				datum.key, datum.nearest, key, value, ok)
+		}
+	}
+}
+
+func TestFindNearestFarApart(t *testing.T) {
+	var small SortedMap[int8, string]
+	small.Insert(-100, "lo")
+	small.Insert(100, "hi")
+	for _, datum := range []struct{ key, nearest int8 }{
+		{50, 100}, {1, 100}, {0, -100}, {-1, -100}, {-128, -100},
+		{127, 100},
+	} {
+		if key, _, _ := FindNearest(&small, datum.key); key != datum.nearest {
+			t.Errorf("FindNearest(%d): expected %d; got %d", datum.key,
+				datum.nearest, key)
+		}
+	}
+	var medium SortedMap[int32, string]
+	medium.Insert(-2_000_000_000, "lo")
+	medium.Insert(2_000_000_000, "hi")
+	if key, _, _ := FindNearest(&medium, 1_000_000_000); key !=
+		2_000_000_000 {
+		t.Errorf("expected 2000000000; got %d", key)
+	}
+	var large SortedMap[int, string]
+	large.Insert(math.MinInt, "min")
+	large.Insert(math.MaxInt, "max")
+	for _, datum := range []struct{ key, nearest int }{
+		{0, math.MaxInt}, {-1, math.MinInt}, {math.MaxInt / 2, math.MaxInt},
+		{math.MinInt / 2, math.MinInt}, {math.MinInt, math.MinInt},
+		{math.MaxInt, math.MaxInt},
+	} {
+		if key, _, _ := FindNearest(&large, datum.key); key != datum.nearest {
+			t.Errorf("FindNearest(%d): expected %d; got %d", datum.key,
+				datum.nearest, key)
+		}
+	}
+}
+
+func TestNearestN(t *testing.T) {
+	var tree SortedMap[float64, int]
+	if items := NearestN(&tree, 5, 3); len(items) != 0 {
+		t.Errorf("expected no items; got %v", items)
+	}
+	for i, key := range []float64{1, 2.5, 4, 6, 6.5, 10} {
+		tree.Insert(key, i)
+	}
+	for _, datum := range []struct {
+		key      float64
+		n        int
+		expected []float64
+	}{
+		{5, 3, []float64{4, 6, 6.5}},
+		{3.25, 2, []float64{2.5, 4}},
+		{6, 4, []float64{6, 6.5, 4, 2.5}},
+		{0, 2, []float64{1, 2.5}},
+		{20, 3, []float64{10, 6.5, 6}},
+		{8, 10, []float64{6.5, 6, 10, 4, 2.5, 1}},
+		{8, 0, nil},
+		{8, -1, nil},
+	} {
+		var keys []float64
+		for _, item := range NearestN(&tree, datum.key, datum.n) {
+			keys = append(keys, item.Key)
+			if value, _ := tree.Find(item.Key); value != item.Value {
+				t.Errorf("expected %d; got %d", value, item.Value)
+			}
+		}
+		if !slices.Equal(keys, datum.expected) {
+			t.Errorf("NearestN(%g, %d): expected %v; got %v", datum.key,
+				datum.n, datum.expected, keys)
+		}
+	}
+	var small SortedMap[int8, string]
+	for _, key := range []int8{-128, -100, 100, 127} {
+		small.Insert(key, strconv.Itoa(int(key)))
+	}
+	for _, datum := range []struct {
+		key      int8
+		n        int
+		expected []int8
+	}{
+		{50, 1, []int8{100}},
+		{50, 3, []int8{100, 127, -100}},
+		{-1, 2, []int8{-100, 100}},
+		{0, 4, []int8{-100, 100, 127, -128}},
+	} {
+		var keys []int8
+		for _, item := range NearestN(&small, datum.key, datum.n) {
+			keys = append(keys, item.Key)
+		}
+		if !slices.Equal(keys, datum.expected) {
+			t.Errorf("NearestN(%d, %d): expected %v; got %v", datum.key,
+				datum.n, datum.expected, keys)
+		}
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	if count := tree.DeleteMany(5, 10, 15, 500, 10, -1); count != 3 {
+		t.Errorf("expected 3; got %d", count)
+	}
+	if count := tree.DeleteMany(); count != 0 {
+		t.Errorf("expected 0; got %d", count)
+	}
+	var purge SortedMap[int, bool]
+	for i := 0; i < 200; i += 2 {
+		purge.Insert(i, true)
+	}
+	if count := tree.DeleteSeq(purge.Keys()); count != 49 {
+		t.Errorf("expected 49; got %d", count)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if tree.Len() != 48 || tree.Contains(5) || tree.Contains(50) {
+		t.Errorf("expected 48 odd keys except 5 and 15; got %v",
+			tree.KeysSlice())
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	tree := churnedTree(10000)
+	keys := tree.KeysSlice()
+	height := tree.Height()
+	tree.Rebuild()
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if !slices.Equal(keys, tree.KeysSlice()) {
+		t.Error("expected the same keys after rebuilding")
+	}
+	optimal := bits.Len(uint(tree.Len()))
+	if tree.Height() > height || tree.Height() > optimal+1 {
+		t.Errorf("expected height <= %d; got %d", optimal+1, tree.Height())
+	}
+	var empty SortedMap[int, int]
+	empty.Rebuild()
+	if empty.Len() != 0 || empty.root != nil {
+		t.Error("expected empty tree")
+	}
+}
+
+// churnedTree returns a tree that has had size random insertions and
+// deletions.
+func churnedTree(size int) *SortedMap[int, int] {
+	var tree SortedMap[int, int]
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := range size * 4 {
+		key := rng.IntN(size * 2)
+		if i%4 == 3 {
+			tree.Delete(key)
+		} else {
+			tree.Insert(key, i)
+		}
+	}
+	return &tree
+}
+
+func TestSwap(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("a b c") {
+		tree.Insert(word, i)
+	}
+	if !tree.Swap("a", "c") {
+		t.Error("expected true; got false")
+	}
+	if values := tree.ValuesSlice(); !slices.Equal(values, []int{2, 1, 0}) {
+		t.Errorf("expected [2 1 0]; got %v", values)
+	}
+	if !tree.Swap("b", "b") || tree.GetOr("b", -1) != 1 {
+		t.Error("expected swapping a key with itself to change nothing")
+	}
+	if tree.Swap("a", "z") || tree.Swap("z", "a") {
+		t.Error("expected false; got true")
+	}
+	if values := tree.ValuesSlice(); !slices.Equal(values, []int{2, 1, 0}) {
+		t.Errorf("expected [2 1 0]; got %v", values)
+	}
+	if tree.Contains("z") {
+		t.Error("expected z not to be inserted")
+	}
+}
+
+func TestRename(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("a b c") {
+		tree.Insert(word, i)
+	}
+	if !tree.Rename("a", "d") || tree.Len() != 3 {
+		t.Errorf("expected true and size 3; got %d", tree.Len())
+	}
+	if keys := strings.Join(tree.KeysSlice(), ""); keys != "bcd" {
+		t.Errorf("expected %q; got %q", "bcd", keys)
+	}
+	if tree.GetOr("d", -1) != 0 {
+		t.Errorf("expected 0; got %d", tree.GetOr("d", -1))
+	}
+	if !tree.Rename("b", "c") || tree.Len() != 2 || tree.GetOr("c",
+		-1) != 1 {
+		t.Errorf("expected c to be overwritten with 1; got %d %d",
+			tree.GetOr("c", -1), tree.Len())
+	}
+	if !tree.Rename("c", "c") || tree.Len() != 2 || tree.GetOr("c",
+		-1) != 1 {
+		t.Error("expected renaming to the same key to change nothing")
+	}
+	if tree.Rename("x", "y") || tree.Contains("y") {
+		t.Error("expected false; got true")
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRangeBackward(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 50 {
+		tree.Insert(i*2, strconv.Itoa(i*2))
+	}
+	for _, bounds := range [][2]int{{9, 21}, {10, 20}, {-10, 5}, {0, 100},
+		{-5, 200}, {20, 10}, {10, 10}, {11, 12}, {100, 200}} {
+		lo, hi := bounds[0], bounds[1]
+		var forward, backward []int
+		for key := range tree.Range(lo, hi) {
+			forward = append(forward, key)
+		}
+		for key, value := range tree.RangeBackward(lo, hi) {
+			if value != strconv.Itoa(key) {
+				t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+			}
+			backward = append(backward, key)
+		}
+		slices.Reverse(forward)
+		if !slices.Equal(forward, backward) {
+			t.Errorf("[%d, %d): expected %v; got %v", lo, hi, forward,
+				backward)
+		}
+	}
+	var keys []int
+	for key := range tree.RangeBackward(0, 100) {
+		keys = append(keys, key)
+		if len(keys) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(keys, []int{98, 96, 94}) {
+		t.Errorf("expected [98 96 94]; got %v", keys)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var older, newer SortedMap[string, int]
+	for i, word := range strings.Fields("b c d f g") {
+		older.Insert(word, i)
+	}
+	for i, word := range strings.Fields("a c d e g") {
+		newer.Insert(word, i)
+	}
+	newer.Insert("c", 10)
+	eq := func(a, b int) bool { return a == b }
+	var out []string
+	for key, kind := range Diff(&older, &newer, eq) {
+		out = append(out, key+":"+kind.String())
+	}
+	expected := "a:Added b:Removed c:Changed e:Added f:Removed"
+	if got := strings.Join(out, " "); got != expected {
+		t.Errorf("expected %q; got %q", expected, got)
+	}
+	for key := range Diff(&older, &older, eq) {
+		t.Errorf("expected no differences; got %q", key)
+	}
+	var empty SortedMap[string, int]
+	out = out[:0]
+	for key, kind := range Diff(&empty, &older, eq) {
+		if kind != Added {
+			t.Errorf("expected Added; got %v", kind)
+		}
+		out = append(out, key)
+	}
+	if got := strings.Join(out, ""); got != "bcdfg" {
+		t.Errorf("expected %q; got %q", "bcdfg", got)
+	}
+	for range Diff(&older, &newer, eq) {
+		break
+	}
+	if s := DiffKind(9).String(); s != "DiffKind(9)" {
+		t.Errorf("expected DiffKind(9); got %q", s)
+	}
+}
+
+func TestMinNMaxN(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 20 {
+		tree.Insert(i, i*i)
+	}
+	keysOf := func(items []Item[int, int]) []int {
+		keys := make([]int, 0, len(items))
+		for _, item := range items {
+			if item.Value != item.Key*item.Key {
+				t.Errorf("expected %d; got %d", item.Key*item.Key,
+					item.Value)
+			}
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}
+	if keys := keysOf(tree.MinN(3)); !slices.Equal(keys, []int{0, 1, 2}) {
+		t.Errorf("expected [0 1 2]; got %v", keys)
+	}
+	if keys := keysOf(tree.MaxN(3)); !slices.Equal(keys,
+		[]int{19, 18, 17}) {
+		t.Errorf("expected [19 18 17]; got %v", keys)
+	}
+	if items := tree.MinN(100); len(items) != 20 || items[19].Key != 19 {
+		t.Errorf("expected all 20 items; got %d", len(items))
+	}
+	if items := tree.MaxN(100); len(items) != 20 || items[19].Key != 0 {
+		t.Errorf("expected all 20 items; got %d", len(items))
+	}
+	if len(tree.MinN(0)) != 0 || len(tree.MaxN(-1)) != 0 {
+		t.Error("expected no items")
+	}
+	var empty SortedMap[int, int]
+	if len(empty.MinN(5)) != 0 || len(empty.MaxN(5)) != 0 {
+		t.Error("expected no items")
+	}
+}
+
+func TestCountFunc(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 30 {
+		tree.Insert(i, strconv.Itoa(i))
+	}
+	var keys []int
+	count := tree.CountFunc(func(key int, value string) bool {
+		keys = append(keys, key)
+		return key%3 == 0 && value == strconv.Itoa(key)
+	})
+	if count != 10 {
+		t.Errorf("expected 10; got %d", count)
+	}
+	if !slices.IsSorted(keys) || len(keys) != 30 {
+		t.Errorf("expected 30 keys in order; got %v", keys)
+	}
+	var empty SortedMap[int, string]
+	if n := empty.CountFunc(func(int, string) bool { return true }); n != 0 {
+		t.Errorf("expected 0; got %d", n)
+	}
+}
+
+func TestForEachRange(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 50 {
+		tree.Insert(i*2, strconv.Itoa(i*2))
+	}
+	var keys []int
+	tree.ForEachRange(9, 21, func(key int, value string) bool {
+		if value != strconv.Itoa(key) {
+			t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	if expected := slices.Collect(tree.RangeKeys(9, 21)); !slices.Equal(
+		keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	keys = keys[:0]
+	tree.ForEachRange(0, 100, func(key int, _ string) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	if !slices.Equal(keys, []int{0, 2, 4}) {
+		t.Errorf("expected [0 2 4]; got %v", keys)
+	}
+	tree.ForEachRange(20, 10, func(key int, _ string) bool {
+		t.Errorf("expected nothing; got %d", key)
+		return true
+	})
+}
+
+func TestGroupBy(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields(
+		"cherry apple banana avocado blueberry cranberry apricot") {
+		tree.Insert(word, i)
+	}
+	groups := GroupBy(&tree, func(word string, _ int) byte {
+		return word[0]
+	})
+	if groups.Len() != 3 {
+		t.Errorf("expected 3 groups; got %d", groups.Len())
+	}
+	expected := map[byte][]int{'a': {1, 6, 3}, 'b': {2, 4}, 'c': {0, 5}}
+	for group, values := range groups.All() {
+		if !slices.Equal(values, expected[group]) {
+			t.Errorf("%c: expected %v; got %v", group, expected[group],
+				values)
+		}
+	}
+	if keys := groups.KeysSlice(); string(keys) != "abc" {
+		t.Errorf("expected abc; got %s", keys)
+	}
+	var empty SortedMap[string, int]
+	if GroupBy(&empty, func(string, int) int { return 0 }).Len() != 0 {
+		t.Error("expected no groups")
+	}
+}
+
+func TestClone(t *testing.T) {
+	var tree SortedMap[int, []int]
+	for i := range 100 {
+		tree.Insert(i, []int{i})
+	}
+	clone := tree.Clone()
+	deep := tree.CloneFunc(slices.Clone)
+	for _, other := range []*SortedMap[int, []int]{clone, deep} {
+		if err := other.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		if other.Len() != tree.Len() || other.Height() != tree.Height() {
+			t.Errorf("expected %d %d; got %d %d", tree.Len(),
+				tree.Height(), other.Len(), other.Height())
+		}
+	}
+	clone.Delete(5)
+	if !tree.Contains(5) {
+		t.Error("expected the original to be unaffected by Delete")
+	}
+	deep.Insert(7, nil)
+	value, _ := deep.Find(8)
+	value[0] = -8
+	if value, _ := tree.Find(8); value[0] != 8 {
+		t.Errorf("expected 8; got %d", value[0])
+	}
+	if value, _ := tree.Find(7); len(value) != 1 {
+		t.Errorf("expected [7]; got %v", value)
+	}
+	value, _ = clone.Find(9)
+	value[0] = -9
+	if value, _ := tree.Find(9); value[0] != -9 {
+		t.Errorf("expected Clone to copy values shallowly; got %d",
+			value[0])
+	}
+}
+
+func TestStats(t *testing.T) {
+	var tree SortedMap[int, int]
+	if nodes, height, blackHeight := tree.Stats(); nodes != 0 ||
+		height != 0 || blackHeight != 0 {
+		t.Errorf("expected 0 0 0; got %d %d %d", nodes, height,
+			blackHeight)
+	}
+	tree = *churnedTree(1000)
+	nodes, height, blackHeight := tree.Stats()
+	if nodes != tree.Len() || height != tree.Height() {
+		t.Errorf("expected %d %d; got %d %d", tree.Len(), tree.Height(),
+			nodes, height)
+	}
+	if blackHeight < 1 || height > 2*blackHeight {
+		t.Errorf("unbalanced: height %d black-height %d", height,
+			blackHeight)
+	}
+}
+
+func TestFirstKeyLastKey(t *testing.T) {
+	var tree SortedMap[string, int]
+	if key, ok := tree.FirstKey(); ok || key != "" {
+		t.Errorf("expected \"\" false; got %q %t", key, ok)
+	}
+	if key, ok := tree.LastKey(); ok || key != "" {
+		t.Errorf("expected \"\" false; got %q %t", key, ok)
+	}
+	for i, word := range strings.Fields("m c x a q z b") {
+		tree.Insert(word, i)
+	}
+	if key, ok := tree.FirstKey(); !ok || key != "a" {
+		t.Errorf("expected a; got %q", key)
+	}
+	if key, ok := tree.LastKey(); !ok || key != "z" {
+		t.Errorf("expected z; got %q", key)
+	}
+	tree.DeleteMin()
+	tree.DeleteMax()
+	if key, _ := tree.FirstKey(); key != "b" {
+		t.Errorf("expected b; got %q", key)
+	}
+	if key, _ := tree.LastKey(); key != "x" {
+		t.Errorf("expected x; got %q", key)
+	}
+}
+
+func TestMinValueMaxValue(t *testing.T) {
+	var tree SortedMap[int, string]
+	if value, ok := tree.MinValue(); ok || value != "" {
+		t.Errorf("expected \"\" false; got %q %t", value, ok)
+	}
+	if value, ok := tree.MaxValue(); ok || value != "" {
+		t.Errorf("expected \"\" false; got %q %t", value, ok)
+	}
+	for _, key := range []int{50, 20, 90, 10, 70} {
+		tree.Insert(key, strconv.Itoa(key))
+	}
+	if value, ok := tree.MinValue(); !ok || value != "10" {
+		t.Errorf("expected 10; got %q", value)
+	}
+	if value, ok := tree.MaxValue(); !ok || value != "90" {
+		t.Errorf("expected 90; got %q", value)
+	}
+	tree.Insert(100, "latest")
+	if value, _ := tree.MaxValue(); value != "latest" {
+		t.Errorf("expected latest; got %q", value)
+	}
+}
+
+func TestCompareAndSwapValue(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("a", 1)
+	tree.Insert("b", 2)
+	eq := func(a, b int) bool { return a == b }
+	if !tree.CompareAndSwapValue("a", 1, 10, eq) {
+		t.Error("expected swap")
+	}
+	if value := tree.GetOr("a", -1); value != 10 {
+		t.Errorf("expected 10; got %d", value)
+	}
+	if tree.CompareAndSwapValue("a", 1, 20, eq) {
+		t.Error("expected no swap for a stale old value")
+	}
+	if value := tree.GetOr("a", -1); value != 10 {
+		t.Errorf("expected 10; got %d", value)
+	}
+	if tree.CompareAndSwapValue("c", 0, 30, eq) || tree.Contains("c") {
+		t.Error("expected no swap for a missing key")
+	}
+	if tree.Len() != 2 {
+		t.Errorf("expected 2; got %d", tree.Len())
+	}
+}
+
+func TestPrefixGroups(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, key := range strings.Fields("docs/a.txt docs/b/c.txt readme " +
+		"src/main.go src/util/x.go src! src zz/") {
+		tree.Insert(key, i)
+	}
+	prefixes := slices.Collect(PrefixGroups(&tree, '/'))
+	expected := []string{"docs/", "readme", "src", "src!", "src/", "zz/"}
+	if !slices.Equal(prefixes, expected) {
+		t.Errorf("expected %v; got %v", expected, prefixes)
+	}
+	prefixes = slices.Collect(PrefixGroups(&tree, '.'))
+	expected = []string{"docs/a.", "docs/b/c.", "readme", "src", "src!",
+		"src/main.", "src/util/x.", "zz/"}
+	if !slices.Equal(prefixes, expected) {
+		t.Errorf("expected %v; got %v", expected, prefixes)
+	}
+	for prefix := range PrefixGroups(&tree, '/') {
+		if prefix != "docs/" {
+			t.Errorf("expected docs/; got %q", prefix)
+		}
+		break
+	}
+	type path string
+	var paths SortedMap[path, bool]
+	paths.Insert("a\xff\xffb", true)
+	paths.Insert("a\xff\xffc", true)
+	paths.Insert("\xff\xffz", true)
+	prefixes = slices.Collect(PrefixGroups(&paths, 0xFF))
+	expected = []string{"a\xff", "\xff"}
+	if !slices.Equal(prefixes, expected) {
+		t.Errorf("expected %q; got %q", expected, prefixes)
+	}
+	for _, prefix := range []string{"", "\xff\xff"} {
+		if end, ok := prefixEnd(prefix); ok {
+			t.Errorf("expected no end for %q; got %q", prefix, end)
+		}
+	}
+	if end, _ := prefixEnd("ab\xff"); end != "ac" {
+		t.Errorf("expected ac; got %q", end)
+	}
+}
+
+func TestSum(t *testing.T) {
+	var counts SortedMap[string, int]
+	if total := Sum(&counts); total != 0 {
+		t.Errorf("expected 0; got %d", total)
+	}
+	for i, word := range strings.Fields("a b c d") {
+		counts.Insert(word, i+1)
+	}
+	if total := Sum(&counts); total != 10 {
+		t.Errorf("expected 10; got %d", total)
+	}
+	var prices SortedMap[int, float64]
+	prices.Insert(1, 0.5)
+	prices.Insert(2, 0.25)
+	if total := Sum(&prices); total != 0.75 {
+		t.Errorf("expected 0.75; got %g", total)
+	}
+}
+
+func TestInsertWith(t *testing.T) {
+	var tree SortedMap[string, int]
+	sum := func(old, incoming int) int { return old + incoming }
+	for _, word := range strings.Fields("b a b c b a") {
+		tree.InsertWith(word, 1, sum)
+	}
+	if tree.Len() != 3 {
+		t.Errorf("expected 3; got %d", tree.Len())
+	}
+	expected := []int{2, 3, 1}
+	if values := tree.ValuesSlice(); !slices.Equal(values, expected) {
+		t.Errorf("expected %v; got %v", expected, values)
+	}
+	if stored := tree.InsertWith("d", 5, sum); stored != 5 {
+		t.Errorf("expected 5 for a new key; got %d", stored)
+	}
+	if stored := tree.InsertWith("b", 5, sum); stored != 8 {
+		t.Errorf("expected 8 for a merged key; got %d", stored)
+	}
+	if value := tree.GetOr("b", -1); value != 8 {
+		t.Errorf("expected 8; got %d", value)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteAndShift(t *testing.T) {
+	var tree SortedMap[uint8, string]
+	for i := range uint8(20) {
+		tree.Insert(i, strconv.Itoa(int(i)))
+	}
+	if !DeleteAndShift(&tree, 5) || !DeleteAndShift(&tree, 0) ||
+		!DeleteAndShift(&tree, 17) {
+		t.Error("expected true")
+	}
+	if DeleteAndShift(&tree, 17) || DeleteAndShift(&tree, 99) {
+		t.Error("expected false")
+	}
+	if tree.Len() != 17 {
+		t.Errorf("expected 17; got %d", tree.Len())
+	}
+	i := uint8(0)
+	for key := range tree.Keys() {
+		if key != i {
+			t.Errorf("expected %d; got %d", i, key)
+		}
+		i++
+	}
+	expected := "1 2 3 4 6 7 8 9 10 11 12 13 14 15 16 17 18"
+	if values := strings.Join(tree.ValuesSlice(), " "); values !=
+		expected {
+		t.Errorf("expected %q; got %q", expected, values)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEqualValues(t *testing.T) {
+	var a, b SortedMap[string, int]
+	if !Equal(&a, &b) {
+		t.Error("expected empty trees to be equal")
+	}
+	words := strings.Fields("m c x a q z b")
+	for i, word := range words {
+		a.Insert(word, i)
+	}
+	for i := len(words) - 1; i >= 0; i-- {
+		b.Insert(words[i], i)
+	}
+	if !Equal(&a, &b) || !Equal(&b, &a) {
+		t.Error("expected trees built in different orders to be equal")
+	}
+	b.Insert("q", 99)
+	if Equal(&a, &b) || !a.Equal(b) {
+		t.Error("expected values but not keys to differ")
+	}
+	b.Insert("q", 4)
+	b.Delete("z")
+	b.Insert("y", 5)
+	if Equal(&a, &b) {
+		t.Error("expected keys to differ")
+	}
+	b.Delete("y")
+	if Equal(&a, &b) {
+		t.Error("expected sizes to differ")
+	}
+}
+
+func TestInsertPairs(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("b", 99)
+	if err := tree.InsertPairs(strings.Fields("c a b"),
+		[]int{3, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if values := tree.ValuesSlice(); !slices.Equal(values,
+		[]int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3]; got %v", values)
+	}
+	err := tree.InsertPairs(strings.Fields("x y"), []int{1})
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Errorf("expected ErrLengthMismatch; got %v", err)
+	}
+	if tree.Len() != 3 || tree.Contains("x") {
+		t.Error("expected nothing to be inserted")
+	}
+	if err := tree.InsertPairs(nil, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeepSmallestLargest(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i*i)
+	}
+	if count := tree.KeepSmallest(60); count != 40 || tree.Len() != 60 {
+		t.Errorf("expected 40 deleted and 60 kept; got %d %d", count,
+			tree.Len())
+	}
+	if count := tree.KeepLargest(10); count != 50 || tree.Len() != 10 {
+		t.Errorf("expected 50 deleted and 10 kept; got %d %d", count,
+			tree.Len())
+	}
+	expected := []int{50, 51, 52, 53, 54, 55, 56, 57, 58, 59}
+	if keys := tree.KeysSlice(); !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if count := tree.KeepSmallest(20); count != 0 || tree.Len() != 10 {
+		t.Errorf("expected nothing deleted; got %d", count)
+	}
+	if count := tree.KeepLargest(-1); count != 10 || tree.Len() != 0 {
+		t.Errorf("expected everything deleted; got %d", count)
+	}
+}
+
+func TestTruncateTo(t *testing.T) {
+	var tree SortedMap[int, int]
+	tree.Reserve(100)
+	for _, limit := range []int{90, 30, 29, 5, 5, 0, -1} {
+		for i := range 100 {
+			tree.Insert(i, i*i)
+		}
+		if count := tree.TruncateTo(limit); count != 100-max(0, limit) {
+			t.Errorf("expected %d deleted; got %d", 100-max(0, limit),
+				count)
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		if keys := tree.KeysSlice(); len(keys) != max(0, limit) ||
+			(len(keys) > 0 && keys[len(keys)-1] != limit-1) {
+			t.Errorf("expected keys 0 to %d; got %v", limit-1, keys)
+		}
+	}
+	deleted := 0
+	tree.SetHooks(Hooks[int, int]{OnDelete: func(int, int) { deleted++ }})
+	if count := tree.TruncateTo(1); count != 0 || deleted != 0 {
+		t.Errorf("expected nothing deleted; got %d %d", count, deleted)
+	}
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	if count := tree.TruncateTo(10); count != 90 || deleted != 90 {
+		t.Errorf("expected 90 deleted; got %d %d", count, deleted)
+	}
+}
+
+func TestFindRef(t *testing.T) {
+	type account struct {
+		Name    string
+		Balance int
+	}
+	var tree SortedMap[int, account]
+	for i := range 10 {
+		tree.Insert(i, account{strconv.Itoa(i), i * 100})
+	}
+	ref, ok := tree.FindRef(3)
+	if !ok || ref.Name != "3" || ref.Balance != 300 {
+		t.Errorf("expected 3 300; got %v", ref)
+	}
+	ref.Balance += 50
+	for i := 10; i < 100; i++ { // rotations must not move values
+		tree.Insert(i, account{})
+	}
+	ref.Balance += 50
+	if value, _ := tree.Find(3); value.Balance != 400 {
+		t.Errorf("expected 400; got %d", value.Balance)
+	}
+	tree.Insert(3, account{"three", 3})
+	if ref.Name != "three" {
+		t.Errorf("expected three; got %q", ref.Name)
+	}
+	if ref, ok := tree.FindRef(100); ok || ref != nil {
+		t.Errorf("expected nil false; got %v %t", ref, ok)
+	}
+}
+
+func TestNewFromSorted(t *testing.T) {
+	for size := range 200 {
+		items := make([]Item[int, int], size)
+		for i := range size {
+			items[i] = Item[int, int]{i * 3, i}
+		}
+		tree := NewFromSorted(items)
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if tree.Len() != size {
+			t.Errorf("expected %d; got %d", size, tree.Len())
+		}
+		for i, key := range tree.KeysSlice() {
+			if key != i*3 {
+				t.Fatalf("expected %d; got %d", i*3, key)
+			}
+		}
+	}
+	tree := NewFromSorted([]Item[string, int]{{"b", 1}, {"a", 2},
+		{"b", 3}})
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	if tree.Len() != 2 || tree.GetOr("b", -1) != 3 {
+		t.Errorf("expected 2 items with b=3; got %d", tree.Len())
+	}
+}
+
+func TestIntersectionKeys(t *testing.T) {
+	var a, b SortedMap[int, bool]
+	for i := range 30 {
+		a.Insert(i*2, true)
+		b.Insert(i*3, false)
+	}
+	keys := slices.Collect(a.IntersectionKeys(&b))
+	expected := []int{0, 6, 12, 18, 24, 30, 36, 42, 48, 54}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if keys := slices.Collect(b.IntersectionKeys(&a)); !slices.Equal(keys,
+		expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	var empty SortedMap[int, bool]
+	for key := range a.IntersectionKeys(&empty) {
+		t.Errorf("expected nothing; got %d", key)
+	}
+	keys = keys[:0]
+	for key := range a.IntersectionKeys(&b) {
+		keys = append(keys, key)
+		if len(keys) == 2 {
+			break
+		}
+	}
+	if !slices.Equal(keys, []int{0, 6}) {
+		t.Errorf("expected [0 6]; got %v", keys)
+	}
+}
+
+func TestFindWithRank(t *testing.T) {
+	tree := churnedTree(500)
+	for i, key := range tree.KeysSlice() {
+		value, rank, ok := tree.FindWithRank(key)
+		if !ok || rank != i || value != tree.GetOr(key, -1) {
+			t.Fatalf("expected %d %d true; got %d %d %t",
+				tree.GetOr(key, -1), i, value, rank, ok)
+		}
+	}
+	var words SortedMap[string, int]
+	for i, word := range strings.Fields("b d f") {
+		words.Insert(word, i+1)
+	}
+	if value, rank, ok := words.FindWithRank("e"); ok || rank != 2 ||
+		value != 0 {
+		t.Errorf("expected 0 2 false; got %d %d %t", value, rank, ok)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	for _, reserved := range []bool{false, true} {
+		var tree SortedMap[int, int]
+		if reserved {
+			tree.Reserve(1000)
+		}
+		for i := range 1000 {
+			tree.Insert(i, -i)
+		}
+		tree.KeepSmallest(100)
+		tree.Shrink()
+		if err := tree.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		if tree.Len() != 100 || len(tree.pool) != 0 || tree.free != nil ||
+			tree.pooled != reserved {
+			t.Errorf("expected 100 items and no spare nodes; got %d %d %d",
+				tree.Len(), len(tree.pool), len(tree.free))
+		}
+		for i, key := range tree.KeysSlice() {
+			if key != i || tree.GetOr(key, 1) != -i {
+				t.Fatalf("expected %d=%d; got %d=%d", i, -i, key,
+					tree.GetOr(key, 1))
+			}
+		}
+		tree.Clear()
+		if reserved != (len(tree.free) == 100) {
+			t.Errorf("expected Clear to keep capacity only if reserved; "+
+				"got %d", len(tree.free))
+		}
+	}
+}
+
+func TestMergeSeq(t *testing.T) {
+	var tree SortedMap[string, int]
+	tree.Insert("b", 10)
+	tree.Insert("d", 20)
+	counts := map[string]int{"a": 1, "b": 2, "c": 3}
+	tree.MergeSeq(maps.All(counts), func(a, b int) int { return a + b })
+	expected := []int{1, 12, 3, 20}
+	if values := tree.ValuesSlice(); !slices.Equal(values, expected) ||
+		tree.Len() != 4 {
+		t.Errorf("expected %v; got %v", expected, values)
+	}
+	tree.MergeSeq(maps.All(map[string]int{"d": 0, "e": 5}), nil)
+	expected = []int{1, 12, 3, 0, 5}
+	if values := tree.ValuesSlice(); !slices.Equal(values, expected) ||
+		tree.Len() != 5 {
+		t.Errorf("expected %v; got %v", expected, values)
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("d b a c e") {
+		tree.Insert(word, i)
+	}
+	items := tree.Drain()
+	if tree.Len() != 0 || tree.root != nil {
+		t.Errorf("expected an empty tree; got %d", tree.Len())
+	}
+	expected := []Item[string, int]{{"a", 2}, {"b", 1}, {"c", 3},
+		{"d", 0}, {"e", 4}}
+	if !slices.Equal(items, expected) {
+		t.Errorf("expected %v; got %v", expected, items)
+	}
+	if items := tree.Drain(); len(items) != 0 {
+		t.Errorf("expected no items; got %v", items)
+	}
+	tree.Reserve(5)
+	for i, word := range strings.Fields("x y z") {
+		tree.Insert(word, i)
+	}
+	if items := tree.Drain(); len(items) != 3 || len(tree.free) != 3 {
+		t.Errorf("expected 3 items and 3 free nodes; got %d %d",
+			len(items), len(tree.free))
+	}
+}
+
+func TestSelectFromEnd(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 10 {
+		tree.Insert(i*10, strconv.Itoa(i))
+	}
+	for i := range 10 {
+		key, value, ok := tree.SelectFromEnd(i)
+		if !ok || key != (9-i)*10 || value != strconv.Itoa(9-i) {
+			t.Errorf("expected %d %d; got %d %q", (9-i)*10, 9-i, key,
+				value)
+		}
+	}
+	for _, i := range []int{-1, 10, 100, math.MinInt} {
+		if key, value, ok := tree.SelectFromEnd(i); ok || key != 0 ||
+			value != "" {
+			t.Errorf("expected 0 \"\" false for %d; got %d %q %t", i, key,
+				value, ok)
+		}
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("d b a c e") {
+		tree.Insert(word, i)
+	}
+	keys := tree.KeysSlice()
+	count := 0
+	for i, item := range tree.Enumerate() {
+		if i != count || item.Key != keys[i] ||
+			item.Value != tree.GetOr(keys[i], -1) {
+			t.Errorf("expected %d %q; got %d %v", count, keys[i], i, item)
+		}
+		count++
+	}
+	if count != 5 {
+		t.Errorf("expected 5; got %d", count)
+	}
+	for i := range tree.Enumerate() {
+		if i == 2 {
+			break
+		}
+	}
+}
+
+func TestNextN(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 10 {
+		tree.Insert(i*10, strconv.Itoa(i*10))
+	}
+	for _, test := range []struct {
+		key, n, expected int
+		ok               bool
+	}{{20, 0, 30, true}, {25, 0, 30, true}, {20, 3, 60, true},
+		{25, 3, 60, true}, {-5, 0, 0, true}, {-5, 9, 90, true},
+		{-5, 10, 0, false}, {90, 0, 0, false}, {85, 0, 90, true},
+		{20, -1, 20, true}, {25, -1, 20, true}, {20, -3, 0, true},
+		{20, -4, 0, false}, {20, math.MaxInt, 0, false},
+		{20, math.MinInt, 0, false}} {
+		key, value, ok := tree.NextN(test.key, test.n)
+		if ok != test.ok || key != test.expected ||
+			(ok && value != strconv.Itoa(key)) {
+			t.Errorf("NextN(%d, %d): expected %d %t; got %d %t", test.key,
+				test.n, test.expected, test.ok, key, ok)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	tree := churnedTree(300)
+	even, odd := tree.Partition(func(key, _ int) bool { return key%2 == 0 })
+	if even.Len()+odd.Len() != tree.Len() {
+		t.Errorf("expected %d; got %d + %d", tree.Len(), even.Len(),
+			odd.Len())
+	}
+	for _, part := range []*SortedMap[int, int]{even, odd} {
+		if err := part.CheckInvariants(); err != nil {
+			t.Error(err)
+		}
+		for key, value := range part.All() {
+			if (key%2 == 0) != (part == even) {
+				t.Errorf("%d is in the wrong partition", key)
+			}
+			if v, ok := tree.Find(key); !ok || v != value {
+				t.Errorf("expected %d; got %d", v, value)
+			}
+		}
+	}
+	all, none := tree.Partition(func(int, int) bool { return true })
+	if all.Len() != tree.Len() || none.Len() != 0 {
+		t.Errorf("expected %d 0; got %d %d", tree.Len(), all.Len(),
+			none.Len())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	build := func(text string) *SortedMap[string, int] {
+		var tree SortedMap[string, int]
+		for _, field := range strings.Fields(text) {
+			key, value, _ := strings.Cut(field, "=")
+			n, _ := strconv.Atoi(value)
+			tree.Insert(key, n)
+		}
+		return &tree
+	}
+	cmpInt := func(a, b int) int { return (a - b) * 10 }
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{{"", "", 0}, {"a=1 b=2", "a=1 b=2", 0}, {"", "a=1", -1},
+		{"a=1", "", 1}, {"a=1", "a=1 b=2", -1}, {"a=1 b=2", "a=1", 1},
+		{"a=1 c=2", "a=1 b=9", 1}, {"a=1 b=9", "a=1 c=2", -1},
+		{"a=1 b=2", "a=1 b=3", -1}, {"a=5", "a=1 b=1", 1},
+		{"b=1", "a=1 b=1", 1}} {
+		a, b := build(test.a), build(test.b)
+		if c := a.Compare(b, cmpInt); c != test.expected {
+			t.Errorf("%q vs %q: expected %d; got %d", test.a, test.b,
+				test.expected, c)
+		}
+	}
+}
+
+func TestDebug(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 3 {
+		tree.Insert(i, i*i)
+	}
+	tree.Insert(-1, 1)
+	// 1 is the black root with children 0 and 2, and -1 is red
+	var out []string
+	for item, red := range tree.Debug() {
+		out = append(out, fmt.Sprintf("%d=%d:%t", item.Key, item.Value,
+			red))
+	}
+	expected := "-1=1:true 0=0:false 1=1:false 2=4:false"
+	if got := strings.Join(out, " "); got != expected {
+		t.Errorf("expected %q; got %q", expected, got)
+	}
+	big := churnedTree(200)
+	reds := 0
+	keys := make([]int, 0, big.Len())
+	for item, red := range big.Debug() {
+		if red {
+			reds++
+		}
+		keys = append(keys, item.Key)
+	}
+	if !slices.Equal(keys, big.KeysSlice()) || reds == 0 {
+		t.Errorf("expected all keys in order and some red; got %d reds",
+			reds)
+	}
+	for range big.Debug() {
+		break
+	}
+}
+
+func TestFloorRank(t *testing.T) {
+	tree := churnedTree(300)
+	keys := tree.KeysSlice()
+	for target := keys[0] - 2; target <= keys[len(keys)-1]+2; target++ {
+		i, found := slices.BinarySearch(keys, target)
+		if !found {
+			i--
+		}
+		key, value, rank, ok := tree.FloorRank(target)
+		if i < 0 {
+			if ok || key != 0 || value != 0 || rank != 0 {
+				t.Errorf("%d: expected no floor; got %d %d %d", target,
+					key, value, rank)
+			}
+			continue
+		}
+		if !ok || key != keys[i] || rank != i ||
+			value != tree.GetOr(key, -1) {
+			t.Fatalf("%d: expected %d at %d; got %d at %d %t", target,
+				keys[i], i, key, rank, ok)
+		}
+	}
+}
+
+func TestInsertChecked(t *testing.T) {
+	var zero SortedMap[string, int]
+	overwrite := NewWithPolicy[string, int](DuplicateOverwrite)
+	keep := NewWithPolicy[string, int](DuplicateKeep)
+	strict := NewWithPolicy[string, int](DuplicateError)
+	for i, tree := range []*SortedMap[string, int]{&zero, overwrite, keep,
+		strict} {
+		for j, word := range strings.Fields("b a c") {
+			if err := tree.InsertChecked(word, j); err != nil {
+				t.Errorf("%d: unexpected error %v", i, err)
+			}
+		}
+		err := tree.InsertChecked("a", 99)
+		if (tree == strict) != errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("%d: unexpected error %v", i, err)
+		}
+		expected := 1
+		if tree == &zero || tree == overwrite {
+			expected = 99
+		}
+		if value := tree.GetOr("a", -1); value != expected ||
+			tree.Len() != 3 {
+			t.Errorf("%d: expected %d; got %d", i, expected, value)
+		}
+		tree.Insert("a", 7) // Insert always overwrites
+		if value := tree.GetOr("a", -1); value != 7 {
+			t.Errorf("%d: expected 7; got %d", i, value)
+		}
+	}
+	if err := strict.Clone().InsertChecked("b", 0); err == nil {
+		t.Error("expected Clone to keep the policy")
+	}
+	if err := strict.InsertChecked("c", 0); err == nil ||
+		err.Error() != "duplicate key: c" {
+		t.Errorf("expected duplicate key: c; got %v", err)
+	}
+}
+
+func TestValueStats(t *testing.T) {
+	var tree SortedMap[string, int]
+	if low, high, count, ok := ValueStats(&tree); ok || low != 0 ||
+		high != 0 || count != 0 {
+		t.Errorf("expected 0 0 0 false; got %d %d %d %t", low, high, count,
+			ok)
+	}
+	for i, word := range strings.Fields("a b c d e") {
+		tree.Insert(word, (i*7)%5-2)
+	}
+	if low, high, count, ok := ValueStats(&tree); !ok || low != -2 ||
+		high != 2 || count != 5 {
+		t.Errorf("expected -2 2 5 true; got %d %d %d %t", low, high, count,
+			ok)
+	}
+	var floats SortedMap[int, float64]
+	for i, value := range []float64{math.NaN(), 3, -1, math.NaN(), 2} {
+		floats.Insert(i, value)
+	}
+	if low, high, count, ok := ValueStats(&floats); !ok || low != -1 ||
+		high != 3 || count != 5 {
+		t.Errorf("expected -1 3 5 true; got %g %g %d %t", low, high, count,
+			ok)
+	}
+}
+
+func TestClearZeroesRecycledNodes(t *testing.T) {
+	var tree SortedMap[string, *[]byte]
+	tree.Reserve(10)
+	buffer := make([]byte, 1<<20)
+	ref := weak.Make(&buffer)
+	for i := range 10 {
+		tree.Insert(strconv.Itoa(i), &buffer)
+	}
+	tree.Clear()
+	if len(tree.free) != 10 {
+		t.Fatalf("expected 10 free nodes; got %d", len(tree.free))
+	}
+	for _, root := range tree.free {
+		if root.key != "" || root.value != nil || root.left != nil ||
+			root.right != nil {
+			t.Fatalf("expected a zeroed node; got %v", *root)
+		}
+	}
+	runtime.GC()
+	if ref.Value() != nil {
+		t.Error("expected the cleared value to be collected")
+	}
+	tree.Insert("x", nil)
+	for i := range 3 {
+		tree.Insert(strconv.Itoa(i), nil)
+	}
+	if items := tree.Drain(); len(items) != 4 {
+		t.Errorf("expected 4; got %d", len(items))
+	}
+	for _, root := range tree.free {
+		if root.key != "" {
+			t.Errorf("expected a zeroed node; got %q", root.key)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var tree SortedMap[int, bool]
+	for i := range 100 {
+		tree.Insert(i, true)
+	}
+	counts := tree.Histogram([]int{-50, 0, 10, 10, 25, 99, 200})
+	expected := []int{0, 10, 0, 15, 74, 1}
+	if !slices.Equal(counts, expected) {
+		t.Errorf("expected %v; got %v", expected, counts)
+	}
+	for _, edges := range [][]int{nil, {5}} {
+		if counts := tree.Histogram(edges); len(counts) != 0 {
+			t.Errorf("expected no counts; got %v", counts)
+		}
+	}
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("expected a panic for unsorted edges")
+		}
+	}()
+	tree.Histogram([]int{0, 20, 10})
+}
+
+func TestStride(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 20 {
+		tree.Insert(i, strconv.Itoa(i))
+	}
+	var keys []int
+	for key, value := range tree.Stride(6) {
+		if value != strconv.Itoa(key) {
+			t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+		}
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []int{0, 6, 12, 18}) {
+		t.Errorf("expected [0 6 12 18]; got %v", keys)
+	}
+	for _, n := range []int{-1, 0, 1} {
+		count := 0
+		for range tree.Stride(n) {
+			count++
+		}
+		if count != 20 {
+			t.Errorf("expected 20 for %d; got %d", n, count)
+		}
+	}
+	keys = keys[:0]
+	for key := range tree.Stride(100) {
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, []int{0}) {
+		t.Errorf("expected [0]; got %v", keys)
+	}
+	for range tree.Stride(2) {
+		break
+	}
+}
+
+func TestContainsRange(t *testing.T) {
+	var tree SortedMap[int, bool]
+	for i := range 10 {
+		tree.Insert(i*10, true)
+	}
+	for _, test := range []struct {
+		lo, hi   int
+		expected bool
+	}{{0, 1, true}, {1, 10, false}, {1, 11, true}, {-5, 0, false},
+		{-5, 1, true}, {90, 91, true}, {91, 200, false}, {20, 20, false},
+		{30, 10, false}} {
+		if ok := tree.ContainsRange(test.lo, test.hi); ok != test.expected {
+			t.Errorf("[%d, %d): expected %t; got %t", test.lo, test.hi,
+				test.expected, ok)
+		}
+		if ok := tree.Count(test.lo, test.hi) > 0; ok != test.expected {
+			t.Errorf("[%d, %d): Count disagrees", test.lo, test.hi)
+		}
+	}
+}
+
+func TestInvert(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i, name := range strings.Fields("ada bob ada cy bob ada") {
+		tree.Insert(i*10, name)
+	}
+	inverted := Invert(&tree)
+	if inverted.Len() != 3 {
+		t.Errorf("expected 3; got %d", inverted.Len())
+	}
+	expected := map[string][]int{"ada": {0, 20, 50}, "bob": {10, 40},
+		"cy": {30}}
+	for name, keys := range inverted.All() {
+		if !slices.Equal(keys, expected[name]) {
+			t.Errorf("%s: expected %v; got %v", name, expected[name], keys)
+		}
+	}
+	if names := inverted.KeysSlice(); !slices.Equal(names,
+		[]string{"ada", "bob", "cy"}) {
+		t.Errorf("expected [ada bob cy]; got %v", names)
+	}
+	var empty SortedMap[int, string]
+	if Invert(&empty).Len() != 0 {
+		t.Error("expected an empty inverted map")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var tree SortedMap[int, int]
+	for i := range 100 {
+		tree.Insert(i, i%3-1)
+	}
+	snapshot := tree.Snapshot()
+	tree.Insert(1000, 1)
+	count := 0
+	for key, value := range snapshot {
+		if value < 0 {
+			tree.Delete(key)
+			tree.Insert(-key-1, value)
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("expected 100; got %d", count)
+	}
+	if tree.Len() != 101 || tree.CountLess(0) != 34 {
+		t.Errorf("expected 101 with 34 negative keys; got %d %d",
+			tree.Len(), tree.CountLess(0))
+	}
+	if err := tree.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	for range snapshot {
+		break
+	}
+}
+
+func TestSumMerge(t *testing.T) {
+	var a, b, c SortedMap[string, int]
+	for i, word := range strings.Fields("apple banana cherry") {
+		a.Insert(word, i+1)
+	}
+	for i, word := range strings.Fields("banana date") {
+		b.Insert(word, (i+1)*10)
+	}
+	for i, word := range strings.Fields("apple date elder") {
+		c.Insert(word, (i+1)*100)
+	}
+	totals := SumMerge(&a, &b, &c)
+	if err := totals.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+	expected := []Item[string, int]{{"apple", 101}, {"banana", 12},
+		{"cherry", 3}, {"date", 220}, {"elder", 300}}
+	if totals.Len() != len(expected) {
+		t.Errorf("expected %d items; got %d", len(expected), totals.Len())
+	}
+	for i, item := range totals.Enumerate() {
+		if item != expected[i] {
+			t.Errorf("expected %v; got %v", expected[i], item)
+		}
+	}
+	totals.Insert("apple", 0)
+	if a.GetOr("apple", -1) != 1 || c.GetOr("apple", -1) != 100 {
+		t.Error("expected the inputs to be unchanged")
+	}
+	if SumMerge[string, int]().Len() != 0 {
+		t.Error("expected an empty result")
+	}
+	if merged := SumMerge(&a); !Equal(merged, &a) {
+		t.Error("expected a copy of a")
+	}
+}
+
+func TestPrefixCount(t *testing.T) {
+	var tree SortedMap[string, bool]
+	keys := strings.Fields("a ab abc abd abz ac b ba \xff \xff\xff " +
+		"\xff\xffa \xffa")
+	for _, key := range keys {
+		tree.Insert(key, true)
+	}
+	for _, prefix := range []string{"", "a", "ab", "abc", "abx", "b", "c",
+		"\xff", "\xff\xff", "\xff\xff\xff", "a\xff"} {
+		expected := 0
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				expected++
+			}
+		}
+		if count := PrefixCount(&tree, prefix); count != expected {
+			t.Errorf("%q: expected %d; got %d", prefix, expected, count)
+		}
+	}
+}
+
+func TestOrderedKeys(t *testing.T) {
+	var tree SortedMap[float64, string]
+	for _, key := range []float64{2.5, -1, 0.125, math.Inf(1), -0.5} {
+		tree.Insert(key, strconv.FormatFloat(key, 'g', -1, 64))
+	}
+	expected := []float64{-1, -0.5, 0.125, 2.5, math.Inf(1)}
+	if keys := tree.KeysSlice(); !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if value, ok := tree.Find(0.125); !ok || value != "0.125" {
+		t.Errorf("expected 0.125; got %q", value)
+	}
+	if count := tree.Count(-1, 1); count != 3 {
+		t.Errorf("expected 3; got %d", count)
+	}
+	// Code constrained by the deprecated Comparable must still compile.
+	if count := countKeys(3, 1, 2, 3); count != 3 {
+		t.Errorf("expected 3; got %d", count)
+	}
+}
+
+func countKeys[K Comparable](keys ...K) int {
+	var tree SortedMap[K, struct{}]
+	for _, key := range keys {
+		tree.Insert(key, struct{}{})
+	}
+	return tree.Len()
+}
+
+func TestFindMany(t *testing.T) {
+	var tree SortedMap[string, int]
+	for i, word := range strings.Fields("a b c") {
+		tree.Insert(word, i+1)
+	}
+	values, found := tree.FindMany(strings.Fields("c x a a"))
+	if !slices.Equal(values, []int{3, 0, 1, 1}) ||
+		!slices.Equal(found, []bool{true, false, true, true}) {
+		t.Errorf("expected [3 0 1 1] [true false true true]; got %v %v",
+			values, found)
+	}
+	if values, found := tree.FindMany(nil); len(values) != 0 ||
+		len(found) != 0 {
+		t.Errorf("expected empty slices; got %v %v", values, found)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	var tree SortedMap[int8, bool]
+	for _, key := range []int8{1, 2, 5, 6, 9, -128, 127} {
+		tree.Insert(key, true)
+	}
+	var gaps []string
+	for after, before := range Gaps(&tree) {
+		gaps = append(gaps, fmt.Sprintf("%d-%d", after, before))
+	}
+	expected := "-128-1 2-5 6-9 9-127"
+	if got := strings.Join(gaps, " "); got != expected {
+		t.Errorf("expected %q; got %q", expected, got)
+	}
+	for after := range Gaps(&tree) {
+		if after != -128 {
+			t.Errorf("expected -128; got %d", after)
+		}
+		break
+	}
+	var contiguous SortedMap[uint, bool]
+	for i := range uint(10) {
+		contiguous.Insert(i, true)
+	}
+	for after, before := range Gaps(&contiguous) {
+		t.Errorf("expected no gaps; got %d-%d", after, before)
+	}
+	contiguous.Clear()
+	contiguous.Insert(5, true)
+	for after, before := range Gaps(&contiguous) {
+		t.Errorf("expected no gaps; got %d-%d", after, before)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+
+package sortedmap
+
+import "iter"
+
+// A SubMap is a read-oriented view of those items in a SortedMap whose
+// keys are in a half-open range [lo, hi). Create one with
+// [SortedMap.SubMap]. For example:
+//
+//	for key, value := range tree.SubMap("a", "n").All()
+//
+// A view holds a reference to its tree rather than a copy of its items,
+// so it is cheap to create and always reflects the tree’s current
+// contents, including any changes made after the view was created. To
+// change the items, change the tree itself.
+type SubMap[K Ordered, V any] struct {
+	tree   *SortedMap[K, V]
+	lo, hi K
+}
+
+// SubMap returns a view of the tree’s items whose keys are in the
+// half-open range [lo, hi). The view is empty if lo >= hi.
+func (me *SortedMap[K, V]) SubMap(lo, hi K) *SubMap[K, V] {
+	return &SubMap[K, V]{tree: me, lo: lo, hi: hi}
+}
+
+// Len returns the number of items in the view. This is O(log n) since it
+// is computed from ranks without visiting the items.
+func (me *SubMap[K, V]) Len() int { return me.tree.Count(me.lo, me.hi) }
+
+// Contains returns true if the key is in the view’s range and in the
+// tree; otherwise returns false.
+func (me *SubMap[K, V]) Contains(key K) bool {
+	return me.inRange(key) && me.tree.Contains(key)
+}
+
+// Find returns the value with the given key and true if the key is in
+// the view’s range and in the tree; otherwise returns V’s zero value and
+// false.
+func (me *SubMap[K, V]) Find(key K) (V, bool) {
+	if !me.inRange(key) {
+		var zero V
+		return zero, false
+	}
+	return me.tree.Find(key)
+}
+
+func (me *SubMap[K, V]) inRange(key K) bool {
+	return me.lo <= key && key < me.hi
+}
+
+// All is a range function for use as an iterable in a for … range loop
+// that returns the view’s keys and values in key order.
+// See also [SortedMap.Range]
+func (me *SubMap[K, V]) All() iter.Seq2[K, V] {
+	return me.tree.Range(me.lo, me.hi)
+}
+
+// Keys is a range function for use as an iterable in a for … range loop
+// that returns the view’s keys in order.
+func (me *SubMap[K, V]) Keys() iter.Seq[K] {
+	return me.tree.RangeKeys(me.lo, me.hi)
+}
+
+// Values is a range function for use as an iterable in a for … range
+// loop that returns the view’s values in key order.
+func (me *SubMap[K, V]) Values() iter.Seq[V] {
+	return me.tree.RangeValues(me.lo, me.hi)
+}
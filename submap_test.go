@@ -0,0 +1,59 @@
+// Copyright © 2024-25 Mark Summerfield. All rights reserved.
+package sortedmap
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestSubMap(t *testing.T) {
+	var tree SortedMap[int, string]
+	for i := range 50 {
+		tree.Insert(i*2, strconv.Itoa(i*2))
+	}
+	view := tree.SubMap(9, 21)
+	expected := []int{10, 12, 14, 16, 18, 20}
+	if view.Len() != len(expected) {
+		t.Errorf("expected %d; got %d", len(expected), view.Len())
+	}
+	if keys := slices.Collect(view.Keys()); !slices.Equal(keys,
+		expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	var keys []int
+	for key, value := range view.All() {
+		if value != strconv.Itoa(key) {
+			t.Errorf("expected %q; got %q", strconv.Itoa(key), value)
+		}
+		keys = append(keys, key)
+	}
+	if !slices.Equal(keys, expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if values := slices.Collect(view.Values()); len(values) != 6 ||
+		values[0] != "10" {
+		t.Errorf("expected [10 … 20]; got %v", values)
+	}
+	if !view.Contains(10) || view.Contains(8) || view.Contains(22) ||
+		view.Contains(11) {
+		t.Error("expected only 10 to be contained")
+	}
+	if value, ok := view.Find(20); !ok || value != "20" {
+		t.Errorf("expected 20; got %q", value)
+	}
+	if _, ok := view.Find(30); ok {
+		t.Error("expected 30 to be outside the view")
+	}
+	tree.Insert(11, "11")
+	tree.Delete(20)
+	tree.Insert(30, "thirty")
+	expected = []int{10, 11, 12, 14, 16, 18}
+	if keys := slices.Collect(view.Keys()); !slices.Equal(keys,
+		expected) || view.Len() != len(expected) {
+		t.Errorf("expected %v; got %v", expected, keys)
+	}
+	if empty := tree.SubMap(21, 9); empty.Len() != 0 {
+		t.Errorf("expected 0; got %d", empty.Len())
+	}
+}